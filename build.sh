@@ -4,4 +4,11 @@ if [ -f ./detect-drain ]; then
   rm ./detect-drain
 fi
 
+if [ -f ./kubectl-detect_drain ]; then
+  rm ./kubectl-detect_drain
+fi
+
 CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o detect-drain main.go
+
+# installed on the PATH under this name the tool runs as `kubectl detect-drain`
+cp ./detect-drain ./kubectl-detect_drain
@@ -3,149 +3,167 @@ package cmd
 import (
 	"fmt"
 	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
 	"github.com/coderwangke/detect-drain/pkg/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const programeName = "detectDrain"
 
+// pluginPrefix is the prefix kubectl finds plugins by, installed as
+// kubectl-detect_drain the program runs as `kubectl detect-drain`.
+const pluginPrefix = "kubectl-"
+
 type DetectDrainCmd struct {
-	out        io.Writer
-	drainNode  string
-	kubeconfig string
+	out       io.Writer
+	nodeNames []string
+	selector  string
+	// configFlags select the cluster and the credentials like kubectl.
+	configFlags *utils.KubeConfigFlags
+	output      string
+	policyFile  string
+	// addressMatchersFile configures how pods with bound addresses are found.
+	addressMatchersFile string
+	// fromDump is the saved cluster state analyzed instead of a live cluster.
+	fromDump string
 }
 
 func NewDetectDrainCmd() *cobra.Command {
 	ddCmd := DetectDrainCmd{
-		out: os.Stdout,
+		out:         os.Stdout,
+		configFlags: utils.NewKubeConfigFlags(),
+	}
+	name := programeName
+	plugin := pluginName()
+	if plugin != "" {
+		name = plugin
 	}
 	cmd := &cobra.Command{
-		Use:  programeName,
-		Args: cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			ddCmd.drainNode = args[0]
-			detect, err := ddCmd.run()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-			} else {
-				fmt.Fprintf(ddCmd.out, "%s", detect)
+		Use: name + " [NODE...] [--selector LABELS]",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && ddCmd.selector == "" {
+				return fmt.Errorf("requires at least one node name or --selector")
 			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the arguments are valid, further errors are no usage errors
+			cmd.SilenceUsage = true
+			ddCmd.nodeNames = args
+			return ddCmd.run()
 		},
+		// main prints the error before exiting with its code
+		SilenceErrors: true,
+	}
+
+	if plugin != "" {
+		// the usage shows the commands as typed with kubectl
+		cmd.SetUsageTemplate(strings.NewReplacer(
+			"{{.UseLine}}", "kubectl {{.UseLine}}",
+			"{{.CommandPath}} [command]", "kubectl {{.CommandPath}} [command]",
+		).Replace(cmd.UsageTemplate()))
 	}
 
 	fs := cmd.PersistentFlags()
 	ddCmd.addFlags(fs)
 
+	cmd.AddCommand(newDrainCmd(&ddCmd))
+	cmd.AddCommand(newSnapshotCmd(&ddCmd))
+	cmd.AddCommand(newServeCmd(&ddCmd))
+
 	return cmd
 }
 
+// pluginName returns the kubectl command the program is run by, empty unless
+// it is installed as plugin.
+func pluginName() string {
+	base := filepath.Base(os.Args[0])
+	if !strings.HasPrefix(base, pluginPrefix) {
+		return ""
+	}
+	return strings.Replace(strings.TrimPrefix(base, pluginPrefix), "_", "-", -1)
+}
+
 func (dd *DetectDrainCmd) addFlags(fs *pflag.FlagSet) {
-	fs.StringVar(&dd.kubeconfig, "kube-config", "/root/.kube/config", "")
+	dd.configFlags.AddFlags(fs)
+	fs.StringVarP(&dd.selector, "selector", "l", "", "Label selector of the nodes drained together with the named ones")
+	fs.StringVarP(&dd.output, "output", "o", report.OUTPUT_TEXT, "Output format, one of text|json|yaml")
+	fs.StringVar(&dd.addressMatchersFile, "address-matchers", "", "File with the annotation and custom resource matchers of pods bound to static or elastic IPs, the built-in matchers if empty")
+	fs.StringVar(&dd.policyFile, "policy", "", "Policy file deciding the severity of the findings, the built-in policy if empty")
+	fs.StringVar(&dd.fromDump, "from-dump", "", "Analyze the kubectl get -o json|yaml output in this file or directory, or an archive of the snapshot command, instead of the live cluster")
 }
 
-func (dd *DetectDrainCmd) run() (string, error) {
-	kubeClient, err := utils.NewKubeClient(dd.kubeconfig)
-	if err != nil {
-		return "", err
+// run prints the report and returns an error carrying the exit code of the
+// verdict unless the drain is safe.
+func (dd *DetectDrainCmd) run() error {
+	if err := report.ValidOutput(dd.output); err != nil {
+		return err
 	}
 
-	dnpClient := check.NewDetectNodePod(dd.drainNode, kubeClient)
-	err = dnpClient.Detect()
+	drainPolicy, err := policy.Load(dd.policyFile)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	dnClient := check.NewDetectNode(dd.drainNode, kubeClient)
-	err = dnClient.Detect()
-	if err != nil {
-		return "", nil
+	// the dump is analyzed without any connection to the cluster
+	var kubeClient *utils.KubeCient
+	if dd.fromDump == "" {
+		kubeClient, err = utils.NewKubeClient(dd.configFlags)
+		if err != nil {
+			return collectError(err)
+		}
 	}
 
-	pdbClient := check.NewDetectPdb(kubeClient)
-	err = pdbClient.Detect()
+	drainReport, err := dd.assess(kubeClient, drainPolicy)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	var podDetails = dnpClient.PodDetails
-	var stsPodDetals = dnpClient.StsPodDetails
-	var isPods = dnpClient.IsolatedPods
-	var nodeDetails = dnClient.NodeDetails
-	var pdbDetails = pdbClient.PdbDetails
-
-	return utils.TabbedString(func(out io.Writer) error {
-		printer := utils.New(out)
-		if len(podDetails) == 0 {
-			printer.Write(0, "ReplicaSetPods:\t <none>\n")
-		} else {
-			printer.Write(0, "ReplicaSetPods:\n")
-			printer.Write(1, "owner\townerKind\tpodName\tnamespace\thasHostPath\tcpuReq\tcpuLimit\tmemReq\tmemLimit\n")
-			for _, pods := range podDetails {
-				for _, pod := range pods {
-					printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-						pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, fmt.Sprintf("%v", pod.HostPath == true), pod.CpuRequest, pod.CpuLimit, pod.MemRequest, pod.MemLimit)
-				}
-			}
-		}
-
-		if len(stsPodDetals) == 0 {
-			printer.Write(0, "StatefulSetPods:\t <none>\n")
-		} else {
-			printer.Write(0, "StatefulSetPods:\n")
-			printer.Write(1, "owner\townerKind\tpodName\tnamespace\thasHostPath\tcpuReq\tcpuLimit\tmemReq\tmemLimit\n")
-
-			for _, pods := range stsPodDetals {
-				for _, pod := range pods {
-					printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-						pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, fmt.Sprintf("%v", pod.HostPath == true), pod.CpuRequest, pod.CpuLimit, pod.MemRequest, pod.MemLimit)
-				}
-			}
-		}
-
-		if len(isPods) == 0 {
-			printer.Write(0, "IsolatedPods:\t<none>\n")
-		} else {
-			printer.Write(0, "IsolatedPods\n  podName\tnamespace\thasHostPath\n")
-			for _, pod := range isPods {
-				printer.Write(1, "%s\t%s\t%s\n", pod.PodName, pod.Namespace, fmt.Sprintf("%v", pod.HostPath == true))
-			}
-		}
+	detect, err := drainReport.Render(dd.output)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(dd.out, "%s", detect)
 
-		if len(nodeDetails) == 0 {
-			printer.Write(0, "Node:\tnone\n")
-		} else {
-			printer.Write(0, "Node:\n")
-			printer.Write(1, "nodeName\tmaxPods\tcurrentPods\tgpu\tschedule\tcpuAllocatable\tmemAllocatable\tcpuAllocated\tmemAllocated\n")
-			for _, node := range nodeDetails {
-				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-					node.NodeName, node.MaxPods, node.CurrentPods, fmt.Sprintf("%v", node.GpuNode == true), fmt.Sprintf("%v", node.Schedule == true), node.CpuAllocatable, node.MemAllocatable, node.CpuAllocated, node.MemAllocated)
-			}
-		}
+	return verdictError(drainReport.Verdict)
+}
 
-		if len(pdbDetails) == 0 {
-			printer.Write(0, "PodDisruptionBudget:\tnone\n")
-		} else {
-			printer.Write(0, "PodDisruptionBudget:\n")
-			for _, pdb := range pdbDetails {
-				printer.Write(0, "pdbName:\t%s\n", pdb.PdbName)
-				printer.Write(0, "pdbNamespace:\t%s\n", pdb.PdbNamespace)
-				printer.Write(0, "pdbMinAvailable:\t%s\n", pdb.PdbMinAvailable)
-				printer.Write(0, "pdbMaxUnavailable:\t%s\n", pdb.PdbMaxUnavailable)
-				printer.Write(0, "pdbAllowed:\t%s\n", pdb.PdbAllowed)
-				if len(pdb.PodDetails) != 0 {
-					printer.Write(1, "owner\townerKind\tpodName\tnamespace\tnodeName\n")
-					for _, pod := range pdb.PodDetails {
-						printer.Write(1, "%s\t%s\t%s\t%s\t%s\n", pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, pod.NodeName)
-					}
-				}
-			}
+// assess runs all checkers against the drain nodes and evaluates the results
+// with the policy. Failures reading the cluster state are returned as collect
+// errors. The state is read from the dump if one is given.
+func (dd *DetectDrainCmd) assess(kubeClient *utils.KubeCient, drainPolicy *policy.Policy) (*report.DrainReport, error) {
+	matchers, err := check.LoadAddressMatchers(dd.addressMatchersFile)
+	if err != nil {
+		return nil, err
+	}
 
-		}
+	var snap *snapshot.Snapshot
+	if dd.fromDump != "" {
+		snap, err = snapshot.FromDump(dd.fromDump, check.AddressResources(matchers)...)
+	} else {
+		snap, err = snapshot.New(kubeClient, check.AddressResources(matchers)...)
+	}
+	if err != nil {
+		return nil, collectError(err)
+	}
 
-		return nil
-	})
+	drainNodes, err := check.ResolveDrainNodes(snap, dd.nodeNames, dd.selector)
+	if err != nil {
+		return nil, err
+	}
 
+	// progress goes to stderr, stdout only carries the report
+	fmt.Fprintln(os.Stderr, "starting detect drain node pods...")
+	drainReport, err := report.Assess(drainNodes, check.NewIndex(snap, matchers), drainPolicy)
+	if err != nil {
+		return nil, collectError(err)
+	}
+	return drainReport, nil
 }
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/drain"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"time"
+)
+
+type DrainCmd struct {
+	*DetectDrainCmd
+	timeout time.Duration
+	force   bool
+}
+
+func newDrainCmd(dd *DetectDrainCmd) *cobra.Command {
+	drainCmd := DrainCmd{
+		DetectDrainCmd: dd,
+	}
+	cmd := &cobra.Command{
+		Use:   "drain [NODE...] [--selector LABELS]",
+		Short: "Cordon the nodes and evict the pods the analysis classified",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && dd.selector == "" {
+				return fmt.Errorf("requires at least one node name or --selector")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			drainCmd.nodeNames = args
+			return drainCmd.run()
+		},
+	}
+
+	drainCmd.addFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (dc *DrainCmd) addFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&dc.timeout, "timeout", 5*time.Minute, "Give up evicting the pods still pending after this long")
+	fs.BoolVar(&dc.force, "force", false, "Also evict the pods the analysis marked as blocked")
+}
+
+// run drains the nodes. Skipped blocked pods end the program with the exit
+// code of a blocked verdict.
+func (dc *DrainCmd) run() error {
+	if dc.fromDump != "" {
+		return fmt.Errorf("drain needs a live cluster, --from-dump is not supported")
+	}
+
+	drainPolicy, err := policy.Load(dc.policyFile)
+	if err != nil {
+		return err
+	}
+
+	kubeClient, err := utils.NewKubeClient(dc.configFlags)
+	if err != nil {
+		return collectError(err)
+	}
+
+	drainReport, err := dc.assess(kubeClient, drainPolicy)
+	if err != nil {
+		return err
+	}
+
+	drainer := drain.NewDrainer(kubeClient, dc.out, dc.timeout, dc.force)
+	for _, node := range drainReport.DrainNodes {
+		if err := drainer.Cordon(node); err != nil {
+			return err
+		}
+	}
+
+	targets := drain.TargetsFromReport(drainReport)
+	if err := drainer.Drain(targets); err != nil {
+		return err
+	}
+
+	return skippedError(targets, dc.force)
+}
+
+// skippedError ends the program with the exit code of a blocked verdict if
+// blocked pods were skipped because the drain wasn't forced.
+func skippedError(targets []drain.Target, force bool) error {
+	if force {
+		return nil
+	}
+	for _, target := range targets {
+		if target.Blocked {
+			return &ExitError{Code: EXIT_BLOCKED, Err: fmt.Errorf("blocked pods were skipped, the nodes are not drained")}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/drain"
+	"testing"
+)
+
+func TestSkippedError(t *testing.T) {
+	blocked := []drain.Target{
+		{PodName: "web-1", Namespace: "default"},
+		{PodName: "debug", Namespace: "default", Blocked: true, Reasons: []string{"pod is not recreated"}},
+	}
+	tests := []struct {
+		name     string
+		targets  []drain.Target
+		force    bool
+		expected int
+	}{
+		{name: "nothing blocked", targets: blocked[:1], expected: EXIT_SAFE},
+		{name: "blocked skipped", targets: blocked, expected: EXIT_BLOCKED},
+		{name: "blocked forced", targets: blocked, force: true, expected: EXIT_SAFE},
+	}
+	for _, test := range tests {
+		if code := ExitCode(skippedError(test.targets, test.force)); code != test.expected {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.expected, code)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/report"
+)
+
+// Exit codes of the program, automation gates the drain on them.
+const (
+	EXIT_SAFE          = 0
+	EXIT_ERROR         = 1
+	EXIT_WARNINGS      = 2
+	EXIT_BLOCKED       = 3
+	EXIT_COLLECT_ERROR = 4
+)
+
+// ExitError carries the exit code the program ends with.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// ExitCode maps an error returned by the command to the exit code, errors
+// without an explicit code are usage errors.
+func ExitCode(err error) int {
+	if err == nil {
+		return EXIT_SAFE
+	}
+	if exitErr, ok := err.(*ExitError); ok {
+		return exitErr.Code
+	}
+	return EXIT_ERROR
+}
+
+// collectError marks err as a failure to collect the cluster state.
+func collectError(err error) error {
+	return &ExitError{Code: EXIT_COLLECT_ERROR, Err: err}
+}
+
+// verdictError turns a verdict other than safe into an error with its exit
+// code.
+func verdictError(verdict string) error {
+	switch verdict {
+	case report.VERDICT_WARNINGS:
+		return &ExitError{Code: EXIT_WARNINGS, Err: fmt.Errorf("drain verdict: %s", verdict)}
+	case report.VERDICT_BLOCKED:
+		return &ExitError{Code: EXIT_BLOCKED, Err: fmt.Errorf("drain verdict: %s", verdict)}
+	default:
+		return nil
+	}
+}
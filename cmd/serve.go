@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/server"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long requests in flight may take to finish once
+// the server is stopped.
+const shutdownTimeout = 10 * time.Second
+
+type ServeCmd struct {
+	*DetectDrainCmd
+	listen         string
+	assessInterval time.Duration
+}
+
+func newServeCmd(dd *DetectDrainCmd) *cobra.Command {
+	serveCmd := ServeCmd{
+		DetectDrainCmd: dd,
+	}
+	cmd := &cobra.Command{
+		Use:   "serve [--listen ADDRESS] [--assess-interval DURATION]",
+		Short: "Answer drain assessments over HTTP and export them as Prometheus metrics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return serveCmd.run()
+		},
+	}
+
+	serveCmd.addFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (sc *ServeCmd) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&sc.listen, "listen", ":8080", "Address the HTTP API and the /metrics endpoint listen on")
+	fs.DurationVar(&sc.assessInterval, "assess-interval", 10*time.Second, "How often the nodes are reassessed if the cluster state changed")
+}
+
+// run serves until SIGINT or SIGTERM.
+func (sc *ServeCmd) run() error {
+	if sc.fromDump != "" {
+		return fmt.Errorf("serve needs a live cluster, --from-dump is not supported")
+	}
+
+	drainPolicy, err := policy.Load(sc.policyFile)
+	if err != nil {
+		return err
+	}
+
+	matchers, err := check.LoadAddressMatchers(sc.addressMatchersFile)
+	if err != nil {
+		return err
+	}
+
+	kubeClient, err := utils.NewKubeClient(sc.configFlags)
+	if err != nil {
+		return collectError(err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	clusterCache := snapshot.NewCache(kubeClient, check.AddressResources(matchers)...)
+	clusterCache.Start(stopCh)
+	drainServer := server.NewServer(clusterCache, matchers, drainPolicy, sc.assessInterval)
+	go drainServer.Run(stopCh)
+
+	httpServer := &http.Server{
+		Addr:    sc.listen,
+		Handler: drainServer,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		fmt.Fprintf(os.Stderr, "starting serve on %s...\n", sc.listen)
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		return err
+	case <-signals:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+)
+
+type SnapshotCmd struct {
+	*DetectDrainCmd
+	archive string
+}
+
+func newSnapshotCmd(dd *DetectDrainCmd) *cobra.Command {
+	snapshotCmd := SnapshotCmd{
+		DetectDrainCmd: dd,
+	}
+	cmd := &cobra.Command{
+		Use:   "snapshot -o ARCHIVE",
+		Short: "Save the cluster state the analysis reads, to replay it with --from-dump",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return snapshotCmd.run()
+		},
+	}
+
+	snapshotCmd.addFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (sc *SnapshotCmd) addFlags(fs *pflag.FlagSet) {
+	// a local flag overrides the report format of the parent command
+	fs.StringVarP(&sc.archive, "output", "o", "state.tar.gz", "Archive the cluster state is written to")
+}
+
+// run writes the archive. The extra resources of the address matchers are
+// captured too, so the replay finds the same bound addresses.
+func (sc *SnapshotCmd) run() error {
+	if sc.fromDump != "" {
+		return fmt.Errorf("snapshot needs a live cluster, --from-dump is not supported")
+	}
+
+	matchers, err := check.LoadAddressMatchers(sc.addressMatchersFile)
+	if err != nil {
+		return err
+	}
+
+	kubeClient, err := utils.NewKubeClient(sc.configFlags)
+	if err != nil {
+		return collectError(err)
+	}
+
+	snap, err := snapshot.New(kubeClient, check.AddressResources(matchers)...)
+	if err != nil {
+		return collectError(err)
+	}
+
+	f, err := os.Create(sc.archive)
+	if err != nil {
+		return err
+	}
+	if err := snap.WriteArchive(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sc.out, "cluster state written to %s\n", sc.archive)
+	return nil
+}
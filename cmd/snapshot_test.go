@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"github.com/spf13/cobra"
+	"testing"
+)
+
+func TestSnapshotOutputFlag(t *testing.T) {
+	dd := &DetectDrainCmd{configFlags: utils.NewKubeConfigFlags()}
+	root := &cobra.Command{Use: programeName}
+	dd.addFlags(root.PersistentFlags())
+
+	sc := &SnapshotCmd{DetectDrainCmd: dd}
+	cmd := &cobra.Command{
+		Use:  "snapshot",
+		RunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	sc.addFlags(cmd.Flags())
+	root.AddCommand(cmd)
+
+	root.SetArgs([]string{"snapshot", "-o", "x.tar.gz"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc.archive != "x.tar.gz" {
+		t.Errorf("expected archive x.tar.gz, got %q", sc.archive)
+	}
+	if dd.output != report.OUTPUT_TEXT {
+		t.Errorf("expected the report format to be left alone, got %q", dd.output)
+	}
+}
@@ -10,6 +10,6 @@ func main() {
 	command := cmd.NewDetectDrainCmd()
 	if err := command.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -0,0 +1,341 @@
+package check
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"net"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	ADDRESS_MATCHERS_API_VERSION = "detectdrain.coderwangke.github.com/v1alpha1"
+	ADDRESS_MATCHERS_KIND        = "AddressMatchers"
+)
+
+const (
+	ADDRESS_ELASTIC_IP = "ElasticIP"
+	ADDRESS_FIXED_IP   = "FixedIP"
+)
+
+// defaultAddressMatchers recognizes the elastic and static IPs of TKE and the
+// fixed IPs of calico.
+const defaultAddressMatchers = `
+apiVersion: detectdrain.coderwangke.github.com/v1alpha1
+kind: AddressMatchers
+matchers:
+- name: tke-eip
+  type: ElasticIP
+  annotation: tke.cloud.tencent.com/eip-attributes
+  release: true
+- name: tke-static-ip
+  type: FixedIP
+  annotation: tke.cloud.tencent.com/vpc-ip-claim-delete-policy
+  value: Never
+  sameNodeLabels:
+  - failure-domain.beta.kubernetes.io/zone
+- name: calico-fixed-ip
+  type: FixedIP
+  annotation: cni.projectcalico.org/ipAddrs
+`
+
+type AddressMatchers struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Matchers   []AddressMatcher `json:"matchers"`
+}
+
+// AddressMatcher recognizes pods bound to a static or elastic IP, either by
+// a pod annotation or by a custom resource naming the pod.
+type AddressMatcher struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Annotation is the pod annotation marking the bound address, Value the
+	// value it must have if set. Annotation values listing IPs are taken as
+	// the addresses, the pod IPs otherwise.
+	Annotation string `json:"annotation,omitempty"`
+	Value      string `json:"value,omitempty"`
+	// APIVersion and Resource name the custom resource binding addresses.
+	// The fields are dot separated paths into its objects, the namespace of
+	// the pod defaults to the namespace of the object.
+	APIVersion        string `json:"apiVersion,omitempty"`
+	Resource          string `json:"resource,omitempty"`
+	PodNameField      string `json:"podNameField,omitempty"`
+	PodNamespaceField string `json:"podNamespaceField,omitempty"`
+	AddressField      string `json:"addressField,omitempty"`
+	// Release is set when the address is released once the pod is deleted,
+	// the rescheduled pod gets another one.
+	Release bool `json:"release,omitempty"`
+	// SameNodeLabels restricts a retained address to nodes with the same
+	// values of these labels as the current node, e.g. the subnet zone.
+	SameNodeLabels []string `json:"sameNodeLabels,omitempty"`
+}
+
+// LoadAddressMatchers reads the matchers file, the built-in matchers if path
+// is empty.
+func LoadAddressMatchers(path string) ([]AddressMatcher, error) {
+	data := []byte(defaultAddressMatchers)
+	if path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	am := &AddressMatchers{}
+	if err := yaml.UnmarshalStrict(data, am); err != nil {
+		return nil, fmt.Errorf("invalid address matchers %s: %v", path, err)
+	}
+	if am.APIVersion != ADDRESS_MATCHERS_API_VERSION || am.Kind != ADDRESS_MATCHERS_KIND {
+		return nil, fmt.Errorf("unsupported address matchers %s %s, must be %s %s", am.APIVersion, am.Kind, ADDRESS_MATCHERS_API_VERSION, ADDRESS_MATCHERS_KIND)
+	}
+	for _, m := range am.Matchers {
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("address matcher %s: %v", m.Name, err)
+		}
+	}
+	return am.Matchers, nil
+}
+
+func (m *AddressMatcher) validate() error {
+	if m.Type != ADDRESS_ELASTIC_IP && m.Type != ADDRESS_FIXED_IP {
+		return fmt.Errorf("unknown type %q, must be one of %s|%s", m.Type, ADDRESS_ELASTIC_IP, ADDRESS_FIXED_IP)
+	}
+	switch {
+	case m.Annotation != "" && m.Resource != "":
+		return fmt.Errorf("either annotation or resource must be set, not both")
+	case m.Annotation == "" && m.Resource == "":
+		return fmt.Errorf("either annotation or resource must be set")
+	case m.Resource != "" && (m.APIVersion == "" || m.PodNameField == ""):
+		return fmt.Errorf("resource needs apiVersion and podNameField")
+	}
+	if m.Resource != "" {
+		if _, err := schema.ParseGroupVersion(m.APIVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *AddressMatcher) resource() (schema.GroupVersionResource, bool) {
+	if m.Resource == "" {
+		return schema.GroupVersionResource{}, false
+	}
+	gv, _ := schema.ParseGroupVersion(m.APIVersion)
+	return gv.WithResource(m.Resource), true
+}
+
+// AddressResources returns the custom resources the matchers read, they have
+// to be in the snapshot.
+func AddressResources(matchers []AddressMatcher) []schema.GroupVersionResource {
+	var gvrs []schema.GroupVersionResource
+	for i := range matchers {
+		if gvr, ok := matchers[i].resource(); ok {
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	return gvrs
+}
+
+// boundAddress is an address bound to a pod by a matcher.
+type boundAddress struct {
+	matcher   *AddressMatcher
+	addresses []string
+}
+
+// addressIndex maps pods to their bound addresses.
+type addressIndex map[string][]boundAddress
+
+func newAddressIndex(snap *snapshot.Snapshot, matchers []AddressMatcher) addressIndex {
+	index := make(addressIndex)
+	for i := range matchers {
+		m := &matchers[i]
+		if gvr, ok := m.resource(); ok {
+			for _, obj := range snap.Resource(gvr) {
+				m.indexObject(index, obj)
+			}
+			continue
+		}
+		for _, pod := range snap.Pods {
+			value, ok := pod.Annotations[m.Annotation]
+			if !ok || (m.Value != "" && value != m.Value) {
+				continue
+			}
+			addresses := parseAddresses(value)
+			if len(addresses) == 0 {
+				addresses = podAddresses(pod)
+			}
+			key := pod.Namespace + "/" + pod.Name
+			index[key] = append(index[key], boundAddress{matcher: m, addresses: addresses})
+		}
+	}
+	return index
+}
+
+func (m *AddressMatcher) indexObject(index addressIndex, obj *unstructured.Unstructured) {
+	podName := nestedString(obj, m.PodNameField)
+	if podName == "" {
+		return
+	}
+	podNamespace := obj.GetNamespace()
+	if m.PodNamespaceField != "" {
+		podNamespace = nestedString(obj, m.PodNamespaceField)
+	}
+
+	var addresses []string
+	if m.AddressField != "" {
+		value, _, _ := unstructured.NestedFieldNoCopy(obj.Object, strings.Split(m.AddressField, ".")...)
+		switch v := value.(type) {
+		case string:
+			addresses = parseAddresses(v)
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					addresses = append(addresses, parseAddresses(s)...)
+				}
+			}
+		}
+	}
+
+	key := podNamespace + "/" + podName
+	index[key] = append(index[key], boundAddress{matcher: m, addresses: addresses})
+}
+
+func nestedString(obj *unstructured.Unstructured, field string) string {
+	value, _, _ := unstructured.NestedString(obj.Object, strings.Split(field, ".")...)
+	return value
+}
+
+// parseAddresses returns the IPs of a comma separated list, also in the json
+// array form calico annotations use.
+func parseAddresses(value string) []string {
+	var addresses []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '[' || r == ']' || r == '"' || r == ' '
+	}) {
+		if ip := net.ParseIP(strings.Split(field, "/")[0]); ip != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses
+}
+
+func podAddresses(pod *corev1.Pod) []string {
+	var addresses []string
+	for _, podIP := range pod.Status.PodIPs {
+		addresses = append(addresses, podIP.IP)
+	}
+	if len(addresses) == 0 && pod.Status.PodIP != "" {
+		addresses = append(addresses, pod.Status.PodIP)
+	}
+	return addresses
+}
+
+// count returns the number of addresses bound to the pods.
+func (index addressIndex) count(pods []*corev1.Pod) int {
+	count := 0
+	for _, pod := range pods {
+		for _, ba := range index[pod.Namespace+"/"+pod.Name] {
+			// a matcher without addresses still binds one
+			if len(ba.addresses) == 0 {
+				count++
+			}
+			count += len(ba.addresses)
+		}
+	}
+	return count
+}
+
+type AddressDetail struct {
+	PodName   string   `json:"podName"`
+	Namespace string   `json:"namespace"`
+	NodeName  string   `json:"nodeName"`
+	Matcher   string   `json:"matcher"`
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses,omitempty"`
+	// Lost is set when the address is released on eviction.
+	Lost bool `json:"lost"`
+	// CandidateNodes are the nodes a retained address can move to.
+	CandidateNodes []string `json:"candidateNodes,omitempty"`
+	// Pinned is set when no node is left the retained address can move to.
+	Pinned bool `json:"pinned"`
+}
+
+type DetectAddress struct {
+	DrainNodes []string
+	Snapshot   *snapshot.Snapshot
+	Matchers   []AddressMatcher
+	// Index is shared with the other checkers of the snapshot, Detect derives
+	// its own if nil.
+	Index          *Index
+	AddressDetails []AddressDetail
+}
+
+func NewDetectAddress(drainNodes []string, snap *snapshot.Snapshot, matchers []AddressMatcher) *DetectAddress {
+	return &DetectAddress{
+		DrainNodes:     drainNodes,
+		Snapshot:       snap,
+		Matchers:       matchers,
+		AddressDetails: []AddressDetail{},
+	}
+}
+
+func (da *DetectAddress) Detect() error {
+	index := orNewIndex(da.Index, da.Snapshot, da.Matchers).addressIndex()
+	for _, drainNode := range da.DrainNodes {
+		node := da.Snapshot.Node(drainNode)
+		for _, pod := range da.Snapshot.NodePods(drainNode) {
+			pd := newPodDetail(da.Snapshot, pod)
+			if !isEvictable(pd) {
+				continue
+			}
+
+			for _, ba := range index[pod.Namespace+"/"+pod.Name] {
+				ad := AddressDetail{
+					PodName:   pod.Name,
+					Namespace: pod.Namespace,
+					NodeName:  drainNode,
+					Matcher:   ba.matcher.Name,
+					Type:      ba.matcher.Type,
+					Addresses: ba.addresses,
+					Lost:      ba.matcher.Release,
+				}
+				if !ad.Lost {
+					ad.CandidateNodes = da.candidateNodes(pod, node, ba.matcher.SameNodeLabels)
+					ad.Pinned = len(ad.CandidateNodes) == 0
+				}
+				da.AddressDetails = append(da.AddressDetails, ad)
+			}
+		}
+	}
+
+	return nil
+}
+
+// candidateNodes returns the nodes the pod is schedulable on which share the
+// labels of the current node the retained address depends on.
+func (da *DetectAddress) candidateNodes(pod *corev1.Pod, current *corev1.Node, sameLabels []string) []string {
+	var candidates []string
+	for _, node := range da.Snapshot.Nodes {
+		if isDrainNode(da.DrainNodes, node.Name) || len(checkPredicates(pod, node)) != 0 {
+			continue
+		}
+		matched := true
+		for _, key := range sameLabels {
+			if current == nil || node.Labels[key] != current.Labels[key] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			candidates = append(candidates, node.Name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
@@ -0,0 +1,143 @@
+package check
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+// newTestSnapshot snapshots a fake cluster holding the objects.
+func newTestSnapshot(t *testing.T, objs ...runtime.Object) *snapshot.Snapshot {
+	t.Helper()
+	client := &utils.KubeCient{
+		ClientSet:     fake.NewSimpleClientset(objs...),
+		DynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		RESTMapper:    meta.NewDefaultRESTMapper(nil),
+	}
+	snap, err := snapshot.New(client)
+	if err != nil {
+		t.Fatalf("failed to snapshot the fake cluster: %v", err)
+	}
+	return snap
+}
+
+func newNode(name string, labels map[string]string, cpu, mem string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(mem),
+				corev1.ResourcePods:   resource.MustParse("110"),
+			},
+		},
+	}
+}
+
+// newPod creates a running, ready pod. The owner is the controller reference
+// given as kind/name, none if kind is empty.
+func newPod(ns, name, nodeName, ownerKind, ownerName, cpu, mem string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			UID:       types.UID(ns + "/" + name),
+			Labels:    map[string]string{"app": name},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse(cpu),
+						corev1.ResourceMemory: resource.MustParse(mem),
+					},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{newControllerRef(ownerKind, ownerName)}
+	}
+	return pod
+}
+
+func newControllerRef(kind, name string) metav1.OwnerReference {
+	apiVersion := "apps/v1"
+	switch kind {
+	case NODE_OWNER:
+		apiVersion = "v1"
+	case JOB_WORKLOAD:
+		apiVersion = "batch/v1"
+	}
+	controller := true
+	return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(kind + "/" + name), Controller: &controller}
+}
+
+func newDeployment(ns, name string, replicas, ready int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: ready},
+	}
+}
+
+func newStatefulSet(ns, name string, replicas, ready int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: ready},
+	}
+}
+
+// newCustomOwned sets a custom resource of an operator as the controller of
+// the object.
+func newCustomOwned(obj metav1.Object, kind, name string) {
+	controller := true
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "example.com/v1",
+		Kind:       kind,
+		Name:       name,
+		UID:        types.UID(kind + "/" + name),
+		Controller: &controller,
+	}})
+}
+
+func newReplicaSet(ns, name, deployment string) *appsv1.ReplicaSet {
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+	}
+	if deployment != "" {
+		rs.OwnerReferences = []metav1.OwnerReference{newControllerRef(DEPLOYMENT_WORKLOAD, deployment)}
+	}
+	return rs
+}
+
+func newPdb(ns, name string, matchLabels map[string]string, allowed int32) *policyv1beta1.PodDisruptionBudget {
+	minAvailable := intstr.FromInt(1)
+	pdb := &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec:       policyv1beta1.PodDisruptionBudgetSpec{MinAvailable: &minAvailable},
+		Status:     policyv1beta1.PodDisruptionBudgetStatus{PodDisruptionsAllowed: allowed},
+	}
+	if matchLabels != nil {
+		pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: matchLabels}
+	}
+	return pdb
+}
@@ -0,0 +1,112 @@
+package check
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"sync"
+)
+
+// Index is what the checkers derive from the whole snapshot regardless of
+// the drain nodes. Assessing the drain of each node on its own shares one
+// index instead of deriving it again for every node. The parts are derived
+// on first use and must not be modified.
+type Index struct {
+	Snapshot *snapshot.Snapshot
+	Matchers []AddressMatcher
+
+	addressesOnce sync.Once
+	addresses     addressIndex
+
+	requestsOnce sync.Once
+	requests     map[string]corev1.ResourceList
+
+	nodesOnce sync.Once
+	nodes     []NodeDetail
+
+	pdbsOnce sync.Once
+	pdbs     *pdbIndex
+
+	servicesOnce sync.Once
+	services     *serviceIndex
+}
+
+func NewIndex(snap *snapshot.Snapshot, matchers []AddressMatcher) *Index {
+	return &Index{
+		Snapshot: snap,
+		Matchers: matchers,
+	}
+}
+
+// orNewIndex returns the shared index, a new one for checkers run on their own.
+func orNewIndex(index *Index, snap *snapshot.Snapshot, matchers []AddressMatcher) *Index {
+	if index != nil {
+		return index
+	}
+	return NewIndex(snap, matchers)
+}
+
+// addressIndex maps the pods to the addresses the matchers bind to them.
+func (index *Index) addressIndex() addressIndex {
+	index.addressesOnce.Do(func() {
+		index.addresses = newAddressIndex(index.Snapshot, index.Matchers)
+	})
+	return index.addresses
+}
+
+// nodeRequests returns the sum of the requests of the pods of the node.
+func (index *Index) nodeRequests(name string) corev1.ResourceList {
+	index.requestsOnce.Do(func() {
+		index.requests = make(map[string]corev1.ResourceList, len(index.Snapshot.Nodes))
+		for _, n := range index.Snapshot.Nodes {
+			index.requests[n.Name], _ = getPodsTotalRequestsAndLimits(index.Snapshot.NodePods(n.Name))
+		}
+	})
+	return index.requests[name]
+}
+
+// nodeDetails describes every node, none of them drained.
+func (index *Index) nodeDetails() []NodeDetail {
+	index.nodesOnce.Do(func() {
+		addresses := index.addressIndex()
+		for _, n := range index.Snapshot.Nodes {
+			index.nodes = append(index.nodes, newNodeDetail(index.Snapshot, addresses, n, index.nodeRequests(n.Name)))
+		}
+	})
+	return index.nodes
+}
+
+// pdbIndex are the budgets with the pods they cover.
+type pdbIndex struct {
+	pdbs []PdbDetail
+	// invalid are the budgets whose selector selects no pod.
+	invalid []PdbDetail
+	// evictable maps the evictable pods to the budgets covering them.
+	evictable map[string][]int
+}
+
+func (index *Index) pdbIndex() *pdbIndex {
+	index.pdbsOnce.Do(func() {
+		index.pdbs = newPdbIndex(index.Snapshot)
+	})
+	return index.pdbs
+}
+
+// serviceIndex are the services with ready endpoints.
+type serviceIndex struct {
+	services []serviceEndpoints
+	// nodeServices maps the nodes to the services with an endpoint on them.
+	nodeServices map[string][]int
+}
+
+type serviceEndpoints struct {
+	service *corev1.Service
+	// nodes are the nodes of the ready endpoint addresses, one per address.
+	nodes []string
+}
+
+func (index *Index) serviceIndex() *serviceIndex {
+	index.servicesOnce.Do(func() {
+		index.services = newServiceIndex(index.Snapshot)
+	})
+	return index.services
+}
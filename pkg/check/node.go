@@ -2,160 +2,213 @@ package check
 
 import (
 	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
 	"github.com/coderwangke/detect-drain/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog"
+	"math"
 	"net"
 )
 
 const NONE_RESOURCE = "none"
 
 type NodeDetail struct {
-	NodeName         string
-	MaxPods          uint
-	CurrentPods      string
-	Eips             int
-	GpuNode          bool
-	Schedule         bool
-	CpuAllocatable   string
-	MemAllocatable   string
-	CpuAllocated     string
-	MemAllocated     string
-	KubeletVersion   string
-	KubeproxyVersion string
-	KernelVersion    string
+	NodeName         string `json:"nodeName"`
+	Drain            bool   `json:"drain"`
+	MaxPods          uint   `json:"maxPods"`
+	CurrentPods      int    `json:"currentPods"`
+	Eips             int    `json:"eips"`
+	GpuNode          bool   `json:"gpuNode"`
+	Schedule         bool   `json:"schedule"`
+	CpuAllocatable   string `json:"cpuAllocatable"`
+	MemAllocatable   string `json:"memAllocatable"`
+	CpuAllocated     string `json:"cpuAllocated"`
+	MemAllocated     string `json:"memAllocated"`
+	KubeletVersion   string `json:"kubeletVersion"`
+	KubeproxyVersion string `json:"kubeproxyVersion"`
+	KernelVersion    string `json:"kernelVersion"`
+	// Allocatable and Allocated are all resources of the node and the sum of
+	// the requests of its pods, including ephemeral-storage, hugepages-* and
+	// extended resources like nvidia.com/gpu.
+	Allocatable corev1.ResourceList `json:"allocatable,omitempty"`
+	Allocated   corev1.ResourceList `json:"allocated,omitempty"`
+	Labels      map[string]string   `json:"labels,omitempty"`
 }
 
 type DetectNode struct {
-	DrainNode   string
-	Client      *utils.KubeCient
+	DrainNodes []string
+	Snapshot   *snapshot.Snapshot
+	Matchers   []AddressMatcher
+	// Index is shared with the other checkers of the snapshot, Detect derives
+	// its own if nil.
+	Index       *Index
 	NodeDetails []NodeDetail
 }
 
-func NewDetectNode(drainNode string, client *utils.KubeCient) *DetectNode {
+func NewDetectNode(drainNodes []string, snap *snapshot.Snapshot, matchers []AddressMatcher) *DetectNode {
 	return &DetectNode{
-		DrainNode:   drainNode,
-		Client:      client,
+		DrainNodes:  drainNodes,
+		Snapshot:    snap,
+		Matchers:    matchers,
 		NodeDetails: []NodeDetail{},
 	}
 }
 
 func (dn *DetectNode) Detect() error {
-	nodeClient := dn.Client.ClientSet.CoreV1().Nodes()
-	// get drain node
-	nodeList, err := nodeClient.List(metav1.ListOptions{})
-
-	if err != nil {
-		klog.Errorf("Failed to list node: %v", err)
-		return err
-	}
-
-	for _, n := range nodeList.Items {
-		cpuReqs, _, memReqs, _ := dn.getNodeResource(&n)
-		currentPods := dn.getNodeNonTerminatedPodsListNumber(&n)
-		nd := NodeDetail{
-			NodeName:         n.Name,
-			MaxPods:          getMaxPods(n.Spec.PodCIDR),
-			CurrentPods:      currentPods,
-			Eips:             0,
-			GpuNode:          false,
-			Schedule:         schedule(&n),
-			CpuAllocatable:   n.Status.Allocatable.Cpu().String(),
-			MemAllocatable:   n.Status.Allocatable.Memory().String(),
-			CpuAllocated:     cpuReqs,
-			MemAllocated:     memReqs,
-			KubeletVersion:   n.Status.NodeInfo.KubeletVersion,
-			KubeproxyVersion: n.Status.NodeInfo.KubeProxyVersion,
-			KernelVersion:    n.Status.NodeInfo.KernelVersion,
-		}
-
+	index := orNewIndex(dn.Index, dn.Snapshot, dn.Matchers)
+	for _, nd := range index.nodeDetails() {
+		nd.Drain = isDrainNode(dn.DrainNodes, nd.NodeName)
 		dn.NodeDetails = append(dn.NodeDetails, nd)
 	}
 
 	return nil
 }
 
-func getMaxPods(cidr string) uint {
-	_, ipNet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		klog.Errorf("Failed to parseCidr %s: %v", cidr, err)
-		return 0
+// newNodeDetail describes the node with the summed requests of its pods.
+func newNodeDetail(snap *snapshot.Snapshot, addresses addressIndex, n *corev1.Node, requests corev1.ResourceList) NodeDetail {
+	pods := snap.NodePods(n.Name)
+	cpuReqs, memReqs := requests[corev1.ResourceCPU], requests[corev1.ResourceMemory]
+	return NodeDetail{
+		NodeName:         n.Name,
+		MaxPods:          getMaxPods(n),
+		CurrentPods:      len(pods),
+		Eips:             getEips(addresses, pods),
+		GpuNode:          gpuNode(n),
+		Schedule:         schedule(n),
+		CpuAllocatable:   n.Status.Allocatable.Cpu().String(),
+		MemAllocatable:   n.Status.Allocatable.Memory().String(),
+		CpuAllocated:     cpuReqs.String(),
+		MemAllocated:     memReqs.String(),
+		KubeletVersion:   n.Status.NodeInfo.KubeletVersion,
+		KubeproxyVersion: n.Status.NodeInfo.KubeProxyVersion,
+		KernelVersion:    n.Status.NodeInfo.KernelVersion,
+		Allocatable:      n.Status.Allocatable,
+		Allocated:        requests,
+		Labels:           n.Labels,
 	}
-	mask, _ := ipNet.Mask.Size()
+}
 
-	cidrIpNum := uint(0)
-	var i = uint(32 - mask - 1)
-	for ; i >= 1; i-- {
-		cidrIpNum += 1 << i
+// ResolveDrainNodes returns the named nodes together with the nodes matching
+// the label selector, each node once.
+func ResolveDrainNodes(snap *snapshot.Snapshot, names []string, selector string) ([]string, error) {
+	var drainNodes []string
+	for _, name := range names {
+		if snap.Node(name) == nil {
+			return nil, fmt.Errorf("node %s not found", name)
+		}
+		if !isDrainNode(drainNodes, name) {
+			drainNodes = append(drainNodes, name)
+		}
 	}
 
-	return cidrIpNum
-}
+	if selector != "" {
+		nodeSelector, err := labels.Parse(selector)
+		if err != nil {
+			klog.Errorf("Failed to parse node selector %s: %v", selector, err)
+			return nil, err
+		}
+		for _, node := range snap.Nodes {
+			if nodeSelector.Matches(labels.Set(node.Labels)) && !isDrainNode(drainNodes, node.Name) {
+				drainNodes = append(drainNodes, node.Name)
+			}
+		}
+	}
 
-func getEips() int {
+	if len(drainNodes) == 0 {
+		return nil, fmt.Errorf("no node to drain")
+	}
 
-	return 0
+	return drainNodes, nil
 }
 
-func gpuNode() bool {
-
+func isDrainNode(drainNodes []string, name string) bool {
+	for _, drainNode := range drainNodes {
+		if drainNode == name {
+			return true
+		}
+	}
 	return false
 }
 
-func schedule(node *corev1.Node) bool {
-	return !node.Spec.Unschedulable
-}
-
-// TODO get nodeNonTerminatedPodsList number
-
-func (dn *DetectNode) getNodeNonTerminatedPodsListNumber(node *corev1.Node) string {
-	pods := dn.nodeNonTerminatedPodsList(node)
-	if pods == nil {
-		return NONE_RESOURCE
+// getMaxPods returns the number of pods the node can run: the pods the
+// kubelet allows, bounded by the addresses of its pod CIDRs. Every pod gets
+// an address of each family on a dual-stack node. Zero means unknown.
+func getMaxPods(node *corev1.Node) uint {
+	var maxPods uint
+	if pods, ok := node.Status.Allocatable[corev1.ResourcePods]; ok && pods.Value() > 0 {
+		maxPods = uint(pods.Value())
 	}
 
-	return fmt.Sprintf("%d", len(pods.Items))
+	cidrs := node.Spec.PodCIDRs
+	if len(cidrs) == 0 && node.Spec.PodCIDR != "" {
+		cidrs = []string{node.Spec.PodCIDR}
+	}
+	for _, cidr := range cidrs {
+		addresses, err := cidrAddresses(cidr)
+		if err != nil {
+			klog.Errorf("Failed to parseCidr %s: %v", cidr, err)
+			continue
+		}
+		if maxPods == 0 || addresses < maxPods {
+			maxPods = addresses
+		}
+	}
 
+	return maxPods
 }
 
-func (dn *DetectNode) getNodeResource(node *corev1.Node) (string, string, string, string) {
-	pods := dn.nodeNonTerminatedPodsList(node)
-	if pods == nil {
-		return NONE_RESOURCE, NONE_RESOURCE, NONE_RESOURCE, NONE_RESOURCE
+// cidrAddresses returns the usable addresses of the CIDR. The network and
+// broadcast addresses of IPv4 and the subnet router anycast address of IPv6
+// are never assigned to pods.
+func cidrAddresses(cidr string) (uint, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, err
+	}
+	ones, bits := ipNet.Mask.Size()
+	hostBits := uint(bits - ones)
+	// large IPv6 ranges have more addresses than any node runs pods
+	if hostBits >= 31 {
+		return math.MaxInt32, nil
 	}
-	reqs, limits := getPodsTotalRequestsAndLimits(pods)
-	cpuReqs, cpuLimits, memoryReqs, memoryLimits :=
-		reqs[corev1.ResourceCPU], limits[corev1.ResourceCPU], reqs[corev1.ResourceMemory], limits[corev1.ResourceMemory]
 
-	return cpuReqs.String(), cpuLimits.String(), memoryReqs.String(), memoryLimits.String()
+	size := uint(1) << hostBits
+	reserved := uint(1)
+	if bits == 8*net.IPv4len {
+		reserved = 2
+	}
+	if size <= reserved {
+		// /31, /32 and /128 ranges use every address
+		return size, nil
+	}
+	return size - reserved, nil
 }
 
-func (dn *DetectNode) nodeNonTerminatedPodsList(node *corev1.Node) *corev1.PodList {
-	podClient := dn.Client.ClientSet.CoreV1().Pods("")
-	fieldSelector, err := fields.ParseSelector("spec.nodeName=" + node.Name + ",status.phase!=" + string(corev1.PodSucceeded) + ",status.phase!=" + string(corev1.PodFailed))
-	if err != nil {
-		klog.Errorf("Failed to parseSelector: %v", err)
-		return nil
-	}
+// getEips counts the static and elastic IPs bound to the pods of the node.
+func getEips(addresses addressIndex, pods []*corev1.Pod) int {
+	return addresses.count(pods)
+}
 
-	nodeNonTerminatedPodsList, err := podClient.List(metav1.ListOptions{
-		FieldSelector: fieldSelector.String(),
-	})
-	if err != nil {
-		klog.Errorf("Failed to list pods of node %s: %v", node.Name, err)
-		return nil
+// gpuNode reports whether the node advertises GPUs.
+func gpuNode(node *corev1.Node) bool {
+	for name, quantity := range node.Status.Allocatable {
+		if isGpuResource(name) && !quantity.IsZero() {
+			return true
+		}
 	}
+	return false
+}
 
-	return nodeNonTerminatedPodsList
+func schedule(node *corev1.Node) bool {
+	return !node.Spec.Unschedulable
 }
 
-func getPodsTotalRequestsAndLimits(podList *corev1.PodList) (reqs map[corev1.ResourceName]resource.Quantity, limits map[corev1.ResourceName]resource.Quantity) {
+func getPodsTotalRequestsAndLimits(pods []*corev1.Pod) (reqs map[corev1.ResourceName]resource.Quantity, limits map[corev1.ResourceName]resource.Quantity) {
 	reqs, limits = map[corev1.ResourceName]resource.Quantity{}, map[corev1.ResourceName]resource.Quantity{}
-	for _, pod := range podList.Items {
-		podReqs, podLimits := utils.PodRequestsAndLimits(&pod)
+	for _, pod := range pods {
+		podReqs, podLimits := utils.PodRequestsAndLimits(pod)
 		for podReqName, podReqValue := range podReqs {
 			if value, ok := reqs[podReqName]; !ok {
 				reqs[podReqName] = podReqValue.DeepCopy()
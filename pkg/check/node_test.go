@@ -0,0 +1,152 @@
+package check
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestDetectNode(t *testing.T) {
+	gpu := newNode("gpu-1", nil, "8", "32Gi")
+	gpu.Status.Allocatable["nvidia.com/gpu"] = resource.MustParse("4")
+
+	cidr := newNode("node-2", nil, "4", "8Gi")
+	cidr.Spec.PodCIDRs = []string{"10.0.1.0/26", "fd00::/120"}
+
+	eip := newPod("default", "gateway", "node-1", REPLICASET_WORKLOAD, "gateway", "100m", "64Mi")
+	eip.Annotations = map[string]string{"tke.cloud.tencent.com/eip-attributes": "{}"}
+	eip.Status.PodIP = "10.0.0.5"
+
+	tests := []struct {
+		name     string
+		objs     []runtime.Object
+		expected map[string]NodeDetail
+	}{
+		{
+			name: "requests of the pods are summed",
+			objs: []runtime.Object{
+				newNode("node-1", nil, "4", "8Gi"),
+				newPod("default", "a", "node-1", REPLICASET_WORKLOAD, "a", "500m", "1Gi"),
+				newPod("default", "b", "node-1", REPLICASET_WORKLOAD, "b", "250m", "512Mi"),
+			},
+			expected: map[string]NodeDetail{
+				"node-1": {Drain: true, MaxPods: 110, CurrentPods: 2, Schedule: true, CpuAllocated: "750m", MemAllocated: "1536Mi"},
+			},
+		},
+		{
+			name: "gpu nodes are recognized by their extended resources",
+			objs: []runtime.Object{gpu},
+			expected: map[string]NodeDetail{
+				"gpu-1": {MaxPods: 110, CurrentPods: 0, GpuNode: true, Schedule: true, CpuAllocated: "0", MemAllocated: "0"},
+			},
+		},
+		{
+			name: "pod capacity is bounded by the smallest pod cidr",
+			objs: []runtime.Object{cidr},
+			expected: map[string]NodeDetail{
+				"node-2": {MaxPods: 62, CurrentPods: 0, Schedule: true, CpuAllocated: "0", MemAllocated: "0"},
+			},
+		},
+		{
+			name: "bound elastic ips are counted",
+			objs: []runtime.Object{newNode("node-1", nil, "4", "8Gi"), eip},
+			expected: map[string]NodeDetail{
+				"node-1": {Drain: true, MaxPods: 110, CurrentPods: 1, Eips: 1, Schedule: true, CpuAllocated: "100m", MemAllocated: "64Mi"},
+			},
+		},
+	}
+
+	matchers, err := LoadAddressMatchers("")
+	if err != nil {
+		t.Fatalf("failed to load the default address matchers: %v", err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dn := NewDetectNode([]string{"node-1"}, newTestSnapshot(t, test.objs...), matchers)
+			if err := dn.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(dn.NodeDetails) != len(test.expected) {
+				t.Fatalf("expected %d nodes, got %d", len(test.expected), len(dn.NodeDetails))
+			}
+			for _, nd := range dn.NodeDetails {
+				expected, ok := test.expected[nd.NodeName]
+				if !ok {
+					t.Errorf("unexpected node %s", nd.NodeName)
+					continue
+				}
+				if nd.Drain != expected.Drain || nd.MaxPods != expected.MaxPods || nd.CurrentPods != expected.CurrentPods ||
+					nd.Eips != expected.Eips || nd.GpuNode != expected.GpuNode || nd.Schedule != expected.Schedule ||
+					nd.CpuAllocated != expected.CpuAllocated || nd.MemAllocated != expected.MemAllocated {
+					t.Errorf("expected node %s to be %+v, got %+v", nd.NodeName, expected, nd)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveDrainNodes(t *testing.T) {
+	snap := newTestSnapshot(t,
+		newNode("node-1", map[string]string{"pool": "a"}, "4", "8Gi"),
+		newNode("node-2", map[string]string{"pool": "a"}, "4", "8Gi"),
+		newNode("node-3", map[string]string{"pool": "b"}, "4", "8Gi"),
+	)
+
+	tests := []struct {
+		name     string
+		names    []string
+		selector string
+		expected []string
+		err      bool
+	}{
+		{name: "named nodes", names: []string{"node-3", "node-1"}, expected: []string{"node-3", "node-1"}},
+		{name: "selected nodes", selector: "pool=a", expected: []string{"node-1", "node-2"}},
+		{name: "named and selected nodes once", names: []string{"node-2"}, selector: "pool=a", expected: []string{"node-2", "node-1"}},
+		{name: "unknown node", names: []string{"node-4"}, err: true},
+		{name: "invalid selector", selector: "pool in (", err: true},
+		{name: "nothing selected", selector: "pool=c", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drainNodes, err := ResolveDrainNodes(snap, test.names, test.selector)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", drainNodes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equalStrings(drainNodes, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, drainNodes)
+			}
+		})
+	}
+}
+
+func TestCidrAddresses(t *testing.T) {
+	tests := map[string]uint{
+		"10.0.0.0/24": 254,
+		"10.0.0.0/32": 1,
+		"fd00::/120":  255,
+		"fd00::/64":   1<<31 - 1,
+	}
+	for cidr, expected := range tests {
+		addresses, err := cidrAddresses(cidr)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", cidr, err)
+			continue
+		}
+		if addresses != expected {
+			t.Errorf("expected %d addresses in %s, got %d", expected, cidr, addresses)
+		}
+	}
+
+	if _, err := cidrAddresses("10.0.0.0"); err == nil {
+		t.Errorf("expected an error for an address without prefix length")
+	}
+}
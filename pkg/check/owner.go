@@ -0,0 +1,73 @@
+package check
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	JOB_WORKLOAD     = "Job"
+	CRONJOB_WORKLOAD = "CronJob"
+	NODE_OWNER       = "Node"
+)
+
+// builtinControllers are the owner kinds the walk up the controller chain
+// climbs through, from a ReplicaSet to its Deployment or from a Job to its
+// CronJob.
+var builtinControllers = map[schema.GroupKind]bool{
+	{Group: appsv1.GroupName, Kind: REPLICASET_WORKLOAD}:  true,
+	{Group: appsv1.GroupName, Kind: DEPLOYMENT_WORKLOAD}:  true,
+	{Group: appsv1.GroupName, Kind: STATEFULSET_WORKLOAD}: true,
+	{Group: appsv1.GroupName, Kind: DAEMONSET_WORKLOAD}:   true,
+	{Group: batchv1.GroupName, Kind: JOB_WORKLOAD}:        true,
+	{Group: batchv1.GroupName, Kind: CRONJOB_WORKLOAD}:    true,
+}
+
+func isBuiltinController(ref *metav1.OwnerReference) bool {
+	return builtinControllers[schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind()]
+}
+
+// workloadOwner returns the nearest built-in workload controlling the pod, nil
+// for a pod not managed by any controller. The walk stops below custom
+// resources, so the StatefulSet of an operator's custom resource is still the
+// owner, and at owners missing from the snapshot. A pod controlled directly
+// by a custom resource or by its Node is owned by it.
+func workloadOwner(snap *snapshot.Snapshot, pod *corev1.Pod) *metav1.OwnerReference {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || !isBuiltinController(owner) {
+		return owner
+	}
+
+	for i := 0; i < snapshot.MaxOwnerDepth; i++ {
+		next := snap.ControllerOf(pod.Namespace, owner)
+		if next == nil || !isBuiltinController(next) {
+			break
+		}
+		owner = next
+	}
+	return owner
+}
+
+// newPodDetail describes the pod together with its workload owner.
+func newPodDetail(snap *snapshot.Snapshot, pod *corev1.Pod) PodDetail {
+	pd := PodDetail{
+		PodName:   pod.Name,
+		Namespace: pod.Namespace,
+		HostPath:  isHostPath(pod),
+		NodeName:  pod.Spec.NodeName,
+		Labels:    pod.Labels,
+	}
+	if owner := workloadOwner(snap, pod); owner != nil {
+		pd.OwnerRef = owner.Name
+		pd.OwnerRefKind = owner.Kind
+	}
+
+	pd.CpuRequest, pd.CpuLimit, pd.MemRequest, pd.MemLimit = getPodRequest(pod)
+	pd.Requests, pd.Limits = utils.PodRequestsAndLimits(pod)
+	return pd
+}
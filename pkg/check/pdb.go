@@ -2,12 +2,11 @@ package check
 
 import (
 	"fmt"
-	"github.com/coderwangke/detect-drain/pkg/utils"
-	appsv1 "k8s.io/api/apps/v1"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/klog"
-	"strings"
+	"sort"
 )
 
 const (
@@ -15,54 +14,126 @@ const (
 	DEPLOYMENT_WORKLOAD  = "Deployment"
 	REPLICASET_WORKLOAD  = "ReplicaSet"
 	ZERO_AVALILABLE      = "0"
-	DAEMONSET_WORKLOAD = "DaemonSet"
+	DAEMONSET_WORKLOAD   = "DaemonSet"
+)
+
+const (
+	PDB_BLOCKS_DRAIN = "BLOCKS DRAIN"
+	PDB_SLOWS_DRAIN  = "WILL SLOW DRAIN"
+	PDB_OK           = "OK"
 )
 
 type PdbDetail struct {
-	PdbName           string
-	PdbNamespace      string
-	PdbMinAvailable   string
-	PdbMaxUnavailable string
-	PdbAllowed        int32
-	PodDetails        []PodDetail
+	PdbName           string `json:"pdbName"`
+	PdbNamespace      string `json:"pdbNamespace"`
+	PdbMinAvailable   string `json:"pdbMinAvailable"`
+	PdbMaxUnavailable string `json:"pdbMaxUnavailable"`
+	PdbAllowed        int32  `json:"pdbAllowed"`
+	// EvictedPods is the number of pods covered by the budget that would be
+	// evicted from the drain nodes together.
+	EvictedPods int32  `json:"evictedPods"`
+	Verdict     string `json:"verdict,omitempty"`
+	// SelectorError explains why the selector of the budget can't select any pod.
+	SelectorError string            `json:"selectorError,omitempty"`
+	PodDetails    []PodDetail       `json:"podDetails,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
 }
 
 type DetectPdb struct {
-	Client     *utils.KubeCient
+	DrainNodes []string
+	Snapshot   *snapshot.Snapshot
+	// Index is shared with the other checkers of the snapshot, Detect derives
+	// its own if nil.
+	Index      *Index
 	PdbDetails []PdbDetail
+	// InvalidPdbs are the budgets whose selector is invalid or empty, the
+	// eviction API ignores them.
+	InvalidPdbs []PdbDetail
 }
 
-func NewDetectPdb(client *utils.KubeCient) *DetectPdb {
+func NewDetectPdb(drainNodes []string, snap *snapshot.Snapshot) *DetectPdb {
 	return &DetectPdb{
-		Client:     client,
-		PdbDetails: []PdbDetail{},
+		DrainNodes:  drainNodes,
+		Snapshot:    snap,
+		PdbDetails:  []PdbDetail{},
+		InvalidPdbs: []PdbDetail{},
 	}
 }
 
 func (dp *DetectPdb) Detect() error {
-	fmt.Println()
-	pdbClient := dp.Client.ClientSet.PolicyV1beta1().PodDisruptionBudgets("")
+	pi := orNewIndex(dp.Index, dp.Snapshot, nil).pdbIndex()
+	dp.InvalidPdbs = append(dp.InvalidPdbs, pi.invalid...)
+
+	// only budgets covering pods on the drain nodes matter, the budget is
+	// shared by all of them
+	evicted := make(map[int]int32)
+	for _, drainNode := range dp.DrainNodes {
+		for _, pod := range dp.Snapshot.NodePods(drainNode) {
+			for _, i := range pi.evictable[pod.Namespace+"/"+pod.Name] {
+				evicted[i]++
+			}
+		}
+	}
+	covering := make([]int, 0, len(evicted))
+	for i := range evicted {
+		covering = append(covering, i)
+	}
+	sort.Ints(covering)
 
-	pdbList, err := pdbClient.List(metav1.ListOptions{})
-	if err != nil {
-		klog.Errorf("Failed to list pdb: %v", err)
-		return err
+	for _, i := range covering {
+		pdbde := pi.pdbs[i]
+		pdbde.EvictedPods = evicted[i]
+		pdbde.Verdict = pdbVerdict(pdbde.EvictedPods, pdbde.PdbAllowed)
+		dp.PdbDetails = append(dp.PdbDetails, pdbde)
 	}
+	return nil
+}
 
-	for _, pdb := range pdbList.Items {
+// newPdbIndex selects the pods of every budget.
+func newPdbIndex(snap *snapshot.Snapshot) *pdbIndex {
+	pi := &pdbIndex{evictable: make(map[string][]int)}
+	for _, pdb := range snap.PodDisruptionBudgets {
 		pdbde := PdbDetail{
 			PdbName:           pdb.Name,
 			PdbNamespace:      pdb.Namespace,
 			PdbMinAvailable:   getMinAvaOrMaxUnAva(pdb.Spec.MinAvailable),
 			PdbMaxUnavailable: getMinAvaOrMaxUnAva(pdb.Spec.MaxUnavailable),
 			PdbAllowed:        pdb.Status.PodDisruptionsAllowed,
+			Labels:            pdb.Labels,
 		}
 
-		pdbde.PodDetails = dp.getSelectedPods(pdb.Namespace, pdb.Spec.Selector)
+		selector, err := getPdbSelector(pdb.Spec.Selector)
+		if err != nil {
+			pdbde.SelectorError = err.Error()
+			pi.invalid = append(pi.invalid, pdbde)
+			continue
+		}
 
-		dp.PdbDetails = append(dp.PdbDetails, pdbde)
+		pdbde.PodDetails = getSelectedPods(snap, pdb.Namespace, selector)
+		for _, pod := range pdbde.PodDetails {
+			if isEvictable(pod) {
+				key := pod.Namespace + "/" + pod.PodName
+				pi.evictable[key] = append(pi.evictable[key], len(pi.pdbs))
+			}
+		}
+		pi.pdbs = append(pi.pdbs, pdbde)
+	}
+	return pi
+}
+
+// pdbVerdict compares the evictions the drain needs with the disruptions the
+// budget currently allows. With no disruption allowed the first eviction is
+// already rejected, with fewer than needed the drain has to wait until the
+// evicted pods are running again elsewhere.
+func pdbVerdict(evicted, allowed int32) string {
+	switch {
+	case allowed <= 0:
+		return PDB_BLOCKS_DRAIN
+	case evicted > allowed:
+		return PDB_SLOWS_DRAIN
+	default:
+		return PDB_OK
 	}
-	return nil
 }
 
 func getMinAvaOrMaxUnAva(num *intstr.IntOrString) string {
@@ -73,125 +144,28 @@ func getMinAvaOrMaxUnAva(num *intstr.IntOrString) string {
 	}
 }
 
-func (dp *DetectPdb) getSelectedPods(ns string, selector *metav1.LabelSelector) []PodDetail {
+func getSelectedPods(snap *snapshot.Snapshot, ns string, selector labels.Selector) []PodDetail {
 	var podDetails = []PodDetail{}
 
-	selectorString := getSelectorString(selector)
-	podClient := dp.Client.ClientSet.CoreV1().Pods(ns)
-	podList, err := podClient.List(metav1.ListOptions{LabelSelector: selectorString})
-	if err != nil {
-		klog.Errorf("DetectPdb: Failed to list pod: %v", err)
-		return podDetails
-	}
-
-	for _, pod := range podList.Items {
-		if pod.OwnerReferences != nil {
-			switch pod.OwnerReferences[0].Kind {
-			case REPLICASET_WORKLOAD:
-				// ReplicaSet or Deployment
-				var ownerRef string
-				var ownerRefKind string
-				var ns = pod.Namespace
-				rsName := pod.OwnerReferences[0].Name
-				deploy := dp.getDeployment(rsName, ns)
-				if deploy != nil {
-					ownerRef = deploy.Name
-					ownerRefKind = DEPLOYMENT_WORKLOAD
-					//fmt.Printf("Get deployment %s", deploy.Name)
-				} else {
-					ownerRef = rsName
-					ownerRefKind = REPLICASET_WORKLOAD
-					//fmt.Printf("Get replicaSets %s", rsName)
-				}
-
-				pd := PodDetail{
-					PodName:      pod.Name,
-					Namespace:    ns,
-					OwnerRef:     ownerRef,
-					OwnerRefKind: ownerRefKind,
-					NodeName:     pod.Spec.NodeName,
-				}
-
-				podDetails = append(podDetails, pd)
-			case STATEFULSET_WORKLOAD:
-				// StatefulSet
-				stsName := pod.OwnerReferences[0].Name
-				//sts := dp.getStatefulSet(stsName, pod.Namespace)
-				pd := PodDetail{
-					PodName:      pod.Name,
-					Namespace:    pod.Namespace,
-					OwnerRef:     stsName,
-					OwnerRefKind: STATEFULSET_WORKLOAD,
-					NodeName:     pod.Spec.NodeName,
-				}
-
-				podDetails = append(podDetails, pd)
-			default:
-				klog.Errorf("Unknown workload kind: %s\n", pod.OwnerReferences[0].Kind)
-			}
-		} else {
-			// isolated pod
-			pd := PodDetail{
-				PodName:      pod.Name,
-				Namespace:    pod.Namespace,
-				OwnerRef:     "",
-				OwnerRefKind: "",
-				NodeName:     pod.Spec.NodeName,
-			}
-
-			podDetails = append(podDetails, pd)
+	// terminated pods are neither evicted nor counted by the budget, the
+	// snapshot leaves them out
+	for _, pod := range snap.NamespacePods(ns) {
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
 		}
+
+		podDetails = append(podDetails, newPodDetail(snap, pod))
 	}
 
 	return podDetails
 }
 
-func (dp *DetectPdb) getDeployment(rsName, ns string) *appsv1.Deployment {
-	var deployName string
-	// get rs
-	rsClient := dp.Client.ClientSet.AppsV1().ReplicaSets(ns)
-	rs, err := rsClient.Get(rsName, metav1.GetOptions{})
-	if err != nil {
-		klog.Errorf("Failed to get replicaSets: %v", err)
-		return nil
+// getPdbSelector converts the budget selector the way the eviction API does:
+// a nil or empty selector matches no pod instead of every pod.
+func getPdbSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return nil, fmt.Errorf("empty selector matches no pods")
 	}
 
-	if rs.OwnerReferences != nil {
-		if rs.OwnerReferences[0].Kind == "Deployment" {
-			deployName = rs.OwnerReferences[0].Name
-		} else {
-			return nil
-		}
-	}
-
-	deployClient := dp.Client.ClientSet.AppsV1().Deployments(ns)
-	deploy, err := deployClient.Get(deployName, metav1.GetOptions{})
-	if err != nil {
-		klog.Errorf("Failed to get deployment: %v", err)
-		return nil
-	}
-
-	return deploy
-}
-
-//func (dp *DetectPdb) getStatefulSet(stsName, ns string) *appsv1.StatefulSet {
-//	// get sts
-//	stsClient := dp.Client.ClientSet.AppsV1().StatefulSets(ns)
-//	sts, err := stsClient.Get(stsName, metav1.GetOptions{})
-//	if err != nil {
-//		klog.Errorf("Failed to get statefulSet: %v", err)
-//		return nil
-//	}
-//
-//	return sts
-//}
-
-func getSelectorString(selector *metav1.LabelSelector) string {
-	labels := selector.MatchLabels
-	var selectorLabels = make([]string, 0, len(labels))
-	for key, value := range labels {
-		selectorLabels = append(selectorLabels, fmt.Sprintf("%s=%s", key, value))
-	}
-	selectorString := strings.Join(selectorLabels, ",")
-	return selectorString
+	return metav1.LabelSelectorAsSelector(selector)
 }
@@ -0,0 +1,134 @@
+package check
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestDetectPdb(t *testing.T) {
+	web := map[string]string{"tier": "web"}
+	webPod := func(name, nodeName string) runtime.Object {
+		pod := newPod("default", name, nodeName, REPLICASET_WORKLOAD, "web", "100m", "64Mi")
+		pod.Labels = web
+		return pod
+	}
+	expressionPdb := func(name string, allowed int32, expressions ...metav1.LabelSelectorRequirement) runtime.Object {
+		pdb := newPdb("default", name, nil, allowed)
+		pdb.Spec.Selector = &metav1.LabelSelector{MatchExpressions: expressions}
+		return pdb
+	}
+
+	tests := []struct {
+		name     string
+		objs     []runtime.Object
+		verdicts map[string]string
+		evicted  map[string]int32
+		invalid  []string
+	}{
+		{
+			name:     "no disruption allowed blocks the drain",
+			objs:     []runtime.Object{webPod("web-a", "node-1"), webPod("web-b", "node-2"), newPdb("default", "web", web, 0)},
+			verdicts: map[string]string{"web": PDB_BLOCKS_DRAIN},
+			evicted:  map[string]int32{"web": 1},
+		},
+		{
+			name:     "fewer disruptions than evictions slow the drain",
+			objs:     []runtime.Object{webPod("web-a", "node-1"), webPod("web-b", "node-1"), webPod("web-c", "node-2"), newPdb("default", "web", web, 1)},
+			verdicts: map[string]string{"web": PDB_SLOWS_DRAIN},
+			evicted:  map[string]int32{"web": 2},
+		},
+		{
+			name:     "enough disruptions are ok",
+			objs:     []runtime.Object{webPod("web-a", "node-1"), webPod("web-b", "node-2"), newPdb("default", "web", web, 1)},
+			verdicts: map[string]string{"web": PDB_OK},
+			evicted:  map[string]int32{"web": 1},
+		},
+		{
+			name:     "budgets without pods on the drain nodes are skipped",
+			objs:     []runtime.Object{webPod("web-b", "node-2"), newPdb("default", "web", web, 0)},
+			verdicts: map[string]string{},
+		},
+		{
+			name:     "budgets of other namespaces don't select the pods",
+			objs:     []runtime.Object{webPod("web-a", "node-1"), newPdb("other", "web", web, 0)},
+			verdicts: map[string]string{},
+		},
+		{
+			name: "daemon set pods are not evicted",
+			objs: func() []runtime.Object {
+				pod := newPod("default", "agent", "node-1", DAEMONSET_WORKLOAD, "agent", "100m", "64Mi")
+				pod.Labels = web
+				return []runtime.Object{pod, newPdb("default", "web", web, 0)}
+			}(),
+			verdicts: map[string]string{},
+		},
+		{
+			name: "match expressions select the pods",
+			objs: []runtime.Object{
+				webPod("web-a", "node-1"), webPod("web-b", "node-1"),
+				expressionPdb("in", 1, metav1.LabelSelectorRequirement{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}}),
+				expressionPdb("exists", 2, metav1.LabelSelectorRequirement{Key: "tier", Operator: metav1.LabelSelectorOpExists}),
+				expressionPdb("not-in", 0, metav1.LabelSelectorRequirement{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"web"}}),
+			},
+			verdicts: map[string]string{"in": PDB_SLOWS_DRAIN, "exists": PDB_OK},
+			evicted:  map[string]int32{"in": 2, "exists": 2},
+		},
+		{
+			name: "match labels and expressions are ANDed",
+			objs: []runtime.Object{
+				webPod("web-a", "node-1"),
+				func() runtime.Object {
+					pdb := newPdb("default", "web-canary", web, 0)
+					pdb.Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{{Key: "track", Operator: metav1.LabelSelectorOpIn, Values: []string{"canary"}}}
+					return pdb
+				}(),
+			},
+			verdicts: map[string]string{},
+		},
+		{
+			name: "invalid expressions are invalid",
+			objs: []runtime.Object{
+				webPod("web-a", "node-1"),
+				expressionPdb("broken", 0, metav1.LabelSelectorRequirement{Key: "tier", Operator: metav1.LabelSelectorOpIn}),
+			},
+			verdicts: map[string]string{},
+			invalid:  []string{"broken"},
+		},
+		{
+			name:     "empty selectors are invalid",
+			objs:     []runtime.Object{webPod("web-a", "node-1"), newPdb("default", "all", nil, 0)},
+			verdicts: map[string]string{},
+			invalid:  []string{"all"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dp := NewDetectPdb([]string{"node-1"}, newTestSnapshot(t, test.objs...))
+			if err := dp.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(dp.PdbDetails) != len(test.verdicts) {
+				t.Fatalf("expected %d budgets, got %+v", len(test.verdicts), dp.PdbDetails)
+			}
+			for _, pdb := range dp.PdbDetails {
+				if pdb.Verdict != test.verdicts[pdb.PdbName] {
+					t.Errorf("expected verdict %q of %s, got %q", test.verdicts[pdb.PdbName], pdb.PdbName, pdb.Verdict)
+				}
+				if pdb.EvictedPods != test.evicted[pdb.PdbName] {
+					t.Errorf("expected %d evicted pods of %s, got %d", test.evicted[pdb.PdbName], pdb.PdbName, pdb.EvictedPods)
+				}
+			}
+
+			var invalid []string
+			for _, pdb := range dp.InvalidPdbs {
+				invalid = append(invalid, pdb.PdbName)
+			}
+			if !equalStrings(invalid, test.invalid) {
+				t.Errorf("expected invalid budgets %v, got %v", test.invalid, invalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package check
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sort"
+	"testing"
+)
+
+func TestDetectNodePod(t *testing.T) {
+	terminated := newPod("default", "done", "node-1", JOB_WORKLOAD, "batch", "100m", "64Mi")
+	terminated.Status.Phase = corev1.PodSucceeded
+	operatedSts := newStatefulSet("default", "db", 1, 1)
+	newCustomOwned(operatedSts, "Database", "db")
+	operatedDeploy := newDeployment("default", "web", 1, 1)
+	newCustomOwned(operatedDeploy, "WebApp", "web")
+
+	tests := []struct {
+		name         string
+		objs         []runtime.Object
+		drainNodes   []string
+		replicaSets  map[string]int
+		statefulSets map[string]int
+		daemonSets   map[string]int
+		others       map[string]int
+		isolated     int
+		evictable    []string
+	}{
+		{
+			name: "deployment pods are grouped by deployment",
+			objs: []runtime.Object{
+				newDeployment("default", "web", 2, 2),
+				newReplicaSet("default", "web-1", "web"),
+				newPod("default", "web-1-a", "node-1", REPLICASET_WORKLOAD, "web-1", "100m", "64Mi"),
+				newPod("default", "web-1-b", "node-2", REPLICASET_WORKLOAD, "web-1", "100m", "64Mi"),
+			},
+			drainNodes:  []string{"node-1"},
+			replicaSets: map[string]int{"web": 1},
+			evictable:   []string{"web-1-a"},
+		},
+		{
+			name: "bare replica sets keep their own name",
+			objs: []runtime.Object{
+				newReplicaSet("default", "cache", ""),
+				newPod("default", "cache-a", "node-1", REPLICASET_WORKLOAD, "cache", "100m", "64Mi"),
+			},
+			drainNodes:  []string{"node-1"},
+			replicaSets: map[string]int{"cache": 1},
+			evictable:   []string{"cache-a"},
+		},
+		{
+			name: "daemon set and mirror pods are not evicted",
+			objs: []runtime.Object{
+				newPod("kube-system", "proxy-a", "node-1", DAEMONSET_WORKLOAD, "proxy", "100m", "64Mi"),
+				newPod("kube-system", "etcd-node-1", "node-1", NODE_OWNER, "node-1", "100m", "64Mi"),
+			},
+			drainNodes:   []string{"node-1"},
+			daemonSets:   map[string]int{"proxy": 1},
+			others:       map[string]int{"Node/node-1": 1},
+			statefulSets: map[string]int{},
+		},
+		{
+			name: "isolated pods are listed but not rescheduled",
+			objs: []runtime.Object{
+				newPod("default", "debug", "node-1", "", "", "100m", "64Mi"),
+				newPod("default", "db-0", "node-1", STATEFULSET_WORKLOAD, "db", "100m", "64Mi"),
+			},
+			drainNodes:   []string{"node-1"},
+			statefulSets: map[string]int{"db": 1},
+			isolated:     1,
+			evictable:    []string{"db-0"},
+		},
+		{
+			name: "workloads of custom resources keep their pods",
+			objs: []runtime.Object{
+				operatedSts,
+				newPod("default", "db-0", "node-1", STATEFULSET_WORKLOAD, "db", "100m", "64Mi"),
+				operatedDeploy,
+				newReplicaSet("default", "web-1", "web"),
+				newPod("default", "web-1-a", "node-1", REPLICASET_WORKLOAD, "web-1", "100m", "64Mi"),
+				newPod("default", "tenant-a", "node-1", "", "", "100m", "64Mi"),
+			},
+			drainNodes:   []string{"node-1"},
+			replicaSets:  map[string]int{"web": 1},
+			statefulSets: map[string]int{"db": 1},
+			isolated:     1,
+			evictable:    []string{"db-0", "web-1-a"},
+		},
+		{
+			name: "terminated pods and pods of other nodes are ignored",
+			objs: []runtime.Object{
+				terminated,
+				newPod("default", "elsewhere", "node-2", STATEFULSET_WORKLOAD, "db", "100m", "64Mi"),
+			},
+			drainNodes: []string{"node-1"},
+		},
+		{
+			name: "pods of every drain node are collected",
+			objs: []runtime.Object{
+				newPod("default", "db-0", "node-1", STATEFULSET_WORKLOAD, "db", "100m", "64Mi"),
+				newPod("default", "db-1", "node-2", STATEFULSET_WORKLOAD, "db", "100m", "64Mi"),
+			},
+			drainNodes:   []string{"node-1", "node-2"},
+			statefulSets: map[string]int{"db": 2},
+			evictable:    []string{"db-0", "db-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dnp := NewDetectNodePod(test.drainNodes, newTestSnapshot(t, test.objs...))
+			if err := dnp.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertGroups(t, "replica set pods", dnp.PodDetails, test.replicaSets)
+			assertGroups(t, "stateful set pods", dnp.StsPodDetails, test.statefulSets)
+			assertGroups(t, "daemon set pods", dnp.DaemonSetPodDetails, test.daemonSets)
+			assertGroups(t, "other pods", dnp.OtherPodDetails, test.others)
+			if len(dnp.IsolatedPods) != test.isolated {
+				t.Errorf("expected %d isolated pods, got %d", test.isolated, len(dnp.IsolatedPods))
+			}
+
+			var evictable []string
+			for _, pod := range dnp.EvictablePods {
+				evictable = append(evictable, pod.Name)
+			}
+			sort.Strings(evictable)
+			if !equalStrings(evictable, test.evictable) {
+				t.Errorf("expected evictable pods %v, got %v", test.evictable, evictable)
+			}
+		})
+	}
+}
+
+func assertGroups(t *testing.T, what string, groups map[string][]PodDetail, expected map[string]int) {
+	t.Helper()
+	if len(groups) != len(expected) {
+		t.Errorf("expected %s of %d owners, got %v", what, len(expected), groups)
+		return
+	}
+	for owner, count := range expected {
+		if len(groups[owner]) != count {
+			t.Errorf("expected %d %s of %s, got %d", count, what, owner, len(groups[owner]))
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
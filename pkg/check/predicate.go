@@ -0,0 +1,184 @@
+package check
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sort"
+)
+
+const (
+	REASON_UNSCHEDULABLE = "node(s) were unschedulable"
+	REASON_NODE_SELECTOR = "node(s) didn't match node selector"
+	REASON_NODE_AFFINITY = "node(s) didn't match required node affinity"
+	REASON_TAINT         = "node(s) had taint %s, that the pod didn't tolerate"
+	REASON_INSUFFICIENT  = "Insufficient %s"
+	REASON_TOO_MANY_PODS = "Too many pods"
+)
+
+// checkPredicates runs the scheduling predicates of pod against node and
+// returns the reasons the node was rejected, none if the pod may land there.
+func checkPredicates(pod *corev1.Pod, node *corev1.Node) []string {
+	var reasons []string
+
+	if !schedule(node) && !toleratesUnschedulable(pod) {
+		reasons = append(reasons, REASON_UNSCHEDULABLE)
+	}
+
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		reasons = append(reasons, REASON_NODE_SELECTOR)
+	}
+
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil {
+		required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		if required != nil && !nodeSelectorTermsMatch(required.NodeSelectorTerms, node) {
+			reasons = append(reasons, REASON_NODE_AFFINITY)
+		}
+	}
+
+	if taint := untoleratedTaint(pod.Spec.Tolerations, node.Spec.Taints); taint != nil {
+		reasons = append(reasons, fmt.Sprintf(REASON_TAINT, formatTaint(taint)))
+	}
+
+	return reasons
+}
+
+// nodeSelectorTermsMatch reports whether node matches any of the terms. The
+// requirements of a single term are ANDed, an empty term matches nothing.
+func nodeSelectorTermsMatch(terms []corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	for _, term := range terms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+
+		if len(term.MatchExpressions) != 0 {
+			selector, err := nodeSelectorRequirementsAsSelector(term.MatchExpressions)
+			if err != nil || !selector.Matches(labels.Set(node.Labels)) {
+				continue
+			}
+		}
+
+		if len(term.MatchFields) != 0 {
+			selector, err := nodeSelectorRequirementsAsFieldSelector(term.MatchFields)
+			if err != nil || !selector.Matches(fields.Set{"metadata.name": node.Name}) {
+				continue
+			}
+		}
+
+		return true
+	}
+	return false
+}
+
+func nodeSelectorRequirementsAsSelector(nsm []corev1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, expr := range nsm {
+		var op selection.Operator
+		switch expr.Operator {
+		case corev1.NodeSelectorOpIn:
+			op = selection.In
+		case corev1.NodeSelectorOpNotIn:
+			op = selection.NotIn
+		case corev1.NodeSelectorOpExists:
+			op = selection.Exists
+		case corev1.NodeSelectorOpDoesNotExist:
+			op = selection.DoesNotExist
+		case corev1.NodeSelectorOpGt:
+			op = selection.GreaterThan
+		case corev1.NodeSelectorOpLt:
+			op = selection.LessThan
+		default:
+			return nil, fmt.Errorf("%q is not a valid node selector operator", expr.Operator)
+		}
+		r, err := labels.NewRequirement(expr.Key, op, expr.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	return selector, nil
+}
+
+func nodeSelectorRequirementsAsFieldSelector(nsr []corev1.NodeSelectorRequirement) (fields.Selector, error) {
+	var selectors []fields.Selector
+	for _, expr := range nsr {
+		switch expr.Operator {
+		case corev1.NodeSelectorOpIn:
+			if len(expr.Values) != 1 {
+				return nil, fmt.Errorf("unexpected number of value (%d) for node field selector operator %q", len(expr.Values), expr.Operator)
+			}
+			selectors = append(selectors, fields.OneTermEqualSelector(expr.Key, expr.Values[0]))
+		case corev1.NodeSelectorOpNotIn:
+			if len(expr.Values) != 1 {
+				return nil, fmt.Errorf("unexpected number of value (%d) for node field selector operator %q", len(expr.Values), expr.Operator)
+			}
+			selectors = append(selectors, fields.OneTermNotEqualSelector(expr.Key, expr.Values[0]))
+		default:
+			return nil, fmt.Errorf("%q is not a valid node field selector operator", expr.Operator)
+		}
+	}
+	return fields.AndSelectors(selectors...), nil
+}
+
+// untoleratedTaint returns the first NoSchedule or NoExecute taint which is
+// not tolerated, PreferNoSchedule taints never keep a pod away.
+func untoleratedTaint(tolerations []corev1.Toleration, taints []corev1.Taint) *corev1.Taint {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return taint
+		}
+	}
+	return nil
+}
+
+func toleratesUnschedulable(pod *corev1.Pod) bool {
+	taint := &corev1.Taint{
+		Key:    corev1.TaintNodeUnschedulable,
+		Effect: corev1.TaintEffectNoSchedule,
+	}
+	for i := range pod.Spec.Tolerations {
+		if pod.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTaint(taint *corev1.Taint) string {
+	if taint.Value == "" {
+		return fmt.Sprintf("{%s:%s}", taint.Key, taint.Effect)
+	}
+	return fmt.Sprintf("{%s=%s:%s}", taint.Key, taint.Value, taint.Effect)
+}
+
+// reasonCounter aggregates rejection reasons over nodes the way the scheduler
+// words its FailedScheduling events, e.g. "3 node(s) were unschedulable".
+type reasonCounter map[string]int
+
+func (rc reasonCounter) add(reasons []string) {
+	for _, reason := range reasons {
+		rc[reason]++
+	}
+}
+
+func (rc reasonCounter) list() []string {
+	var reasons []string
+	for reason, count := range rc {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+	return reasons
+}
@@ -0,0 +1,118 @@
+package check
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func TestCheckPredicates(t *testing.T) {
+	zoneAffinity := func(zones ...string) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: zones}},
+				}},
+			},
+		}}
+	}
+	gpuTaint := corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name    string
+		node    func(node *corev1.Node)
+		pod     func(pod *corev1.Pod)
+		reasons []string
+	}{
+		{
+			name: "schedulable nodes without taints take any pod",
+		},
+		{
+			name:    "unschedulable nodes are rejected",
+			node:    func(node *corev1.Node) { node.Spec.Unschedulable = true },
+			reasons: []string{"1 node(s) were unschedulable"},
+		},
+		{
+			name: "pods tolerating the unschedulable taint may land on cordoned nodes",
+			node: func(node *corev1.Node) { node.Spec.Unschedulable = true },
+			pod: func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{{Key: corev1.TaintNodeUnschedulable, Operator: corev1.TolerationOpExists}}
+			},
+		},
+		{
+			name:    "node selectors have to match",
+			pod:     func(pod *corev1.Pod) { pod.Spec.NodeSelector = map[string]string{"zone": "b"} },
+			reasons: []string{"1 node(s) didn't match node selector"},
+		},
+		{
+			name: "matching required node affinity is eligible",
+			pod:  func(pod *corev1.Pod) { pod.Spec.Affinity = zoneAffinity("a", "b") },
+		},
+		{
+			name:    "required node affinity has to match",
+			pod:     func(pod *corev1.Pod) { pod.Spec.Affinity = zoneAffinity("b") },
+			reasons: []string{"1 node(s) didn't match required node affinity"},
+		},
+		{
+			name:    "untolerated taints are rejected",
+			node:    func(node *corev1.Node) { node.Spec.Taints = []corev1.Taint{gpuTaint} },
+			reasons: []string{"1 node(s) had taint {dedicated=gpu:NoSchedule}, that the pod didn't tolerate"},
+		},
+		{
+			name: "tolerated taints are eligible",
+			node: func(node *corev1.Node) { node.Spec.Taints = []corev1.Taint{gpuTaint} },
+			pod: func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+			},
+		},
+		{
+			name: "prefer no schedule taints never keep pods away",
+			node: func(node *corev1.Node) {
+				node.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectPreferNoSchedule}}
+			},
+		},
+		{
+			name: "every failed predicate is reported",
+			node: func(node *corev1.Node) {
+				node.Spec.Unschedulable = true
+				node.Spec.Taints = []corev1.Taint{gpuTaint}
+			},
+			pod: func(pod *corev1.Pod) { pod.Spec.NodeSelector = map[string]string{"zone": "b"} },
+			reasons: []string{
+				"1 node(s) didn't match node selector",
+				"1 node(s) had taint {dedicated=gpu:NoSchedule}, that the pod didn't tolerate",
+				"1 node(s) were unschedulable",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newNode("node-2", map[string]string{"zone": "a"}, "4", "8Gi")
+			if test.node != nil {
+				test.node(node)
+			}
+			pod := newPod("default", "web-a", "node-1", REPLICASET_WORKLOAD, "web", "100m", "64Mi")
+			if test.pod != nil {
+				test.pod(pod)
+			}
+
+			snap := newTestSnapshot(t, newNode("node-1", nil, "4", "8Gi"), node, pod)
+			dr := NewDetectReschedule([]string{"node-1"}, snap, []*corev1.Pod{pod})
+			if err := dr.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			pp := dr.PodPlacements[0]
+			eligible := 0
+			if len(test.reasons) == 0 {
+				eligible = 1
+			}
+			if pp.EligibleNodes != eligible {
+				t.Errorf("expected %d eligible nodes, got %d", eligible, pp.EligibleNodes)
+			}
+			if !equalStrings(pp.Reasons, test.reasons) {
+				t.Errorf("expected reasons %v, got %v", test.reasons, pp.Reasons)
+			}
+		})
+	}
+}
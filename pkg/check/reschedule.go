@@ -0,0 +1,242 @@
+package check
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sort"
+)
+
+// PodPlacement is the simulated rescheduling result of one evicted pod.
+type PodPlacement struct {
+	PodName    string `json:"podName"`
+	Namespace  string `json:"namespace"`
+	CpuRequest string `json:"cpuRequest"`
+	MemRequest string `json:"memRequest"`
+	// Requests are all resources the pod requests, the pod only fits on
+	// nodes with enough of each of them left.
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	// EligibleNodes is the number of destination nodes passing the scheduling
+	// predicates: node selector, required node affinity, taints and tolerations.
+	EligibleNodes int `json:"eligibleNodes"`
+	// FitNodes is the number of eligible nodes the pod fits on before any
+	// other evicted pod has been placed.
+	FitNodes int `json:"fitNodes"`
+	// TargetNode is the node the pod was packed onto, empty if it fits nowhere.
+	TargetNode string `json:"targetNode,omitempty"`
+	// Reasons explains why the rejected destination nodes were not chosen.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// NodeUtilization is the projected utilization of a destination node after
+// the evicted pods have been packed onto it.
+type NodeUtilization struct {
+	NodeName       string `json:"nodeName"`
+	CpuAllocatable string `json:"cpuAllocatable"`
+	MemAllocatable string `json:"memAllocatable"`
+	CpuRequested   string `json:"cpuRequested"`
+	MemRequested   string `json:"memRequested"`
+	CpuPercent     int64  `json:"cpuPercent"`
+	MemPercent     int64  `json:"memPercent"`
+	// Allocatable and Requested cover all resources of the node, including
+	// ephemeral-storage, hugepages-* and extended resources.
+	Allocatable corev1.ResourceList `json:"allocatable,omitempty"`
+	Requested   corev1.ResourceList `json:"requested,omitempty"`
+	NewPods     int                 `json:"newPods"`
+	// MaxPods is the pod capacity of the node, Pods the pods it runs after
+	// the drain and PodHeadroom the pod slots left. Zero MaxPods is unknown,
+	// the headroom is nil then.
+	MaxPods     uint `json:"maxPods"`
+	Pods        int  `json:"pods"`
+	PodHeadroom *int `json:"podHeadroom,omitempty"`
+}
+
+type DetectReschedule struct {
+	DrainNodes []string
+	Snapshot   *snapshot.Snapshot
+	Pods       []*corev1.Pod
+	// Index is shared with the other checkers of the snapshot, Detect derives
+	// its own if nil.
+	Index            *Index
+	PodPlacements    []PodPlacement
+	NodeUtilizations []NodeUtilization
+}
+
+type nodeCapacity struct {
+	node        *corev1.Node
+	allocatable corev1.ResourceList
+	requested   corev1.ResourceList
+	maxPods     uint
+	pods        int
+	newPods     int
+}
+
+func NewDetectReschedule(drainNodes []string, snap *snapshot.Snapshot, pods []*corev1.Pod) *DetectReschedule {
+	return &DetectReschedule{
+		DrainNodes:       drainNodes,
+		Snapshot:         snap,
+		Pods:             pods,
+		PodPlacements:    []PodPlacement{},
+		NodeUtilizations: []NodeUtilization{},
+	}
+}
+
+func (dr *DetectReschedule) Detect() error {
+	index := orNewIndex(dr.Index, dr.Snapshot, nil)
+	var nodes []*nodeCapacity
+	for _, n := range dr.Snapshot.Nodes {
+		if isDrainNode(dr.DrainNodes, n.Name) {
+			continue
+		}
+		nodes = append(nodes, &nodeCapacity{
+			node:        n,
+			allocatable: n.Status.Allocatable,
+			// the evicted pods are added to a copy, the index is shared
+			requested: index.nodeRequests(n.Name).DeepCopy(),
+			maxPods:   getMaxPods(n),
+			pods:      len(dr.Snapshot.NodePods(n.Name)),
+		})
+	}
+
+	// place the biggest pods first, they are the hardest to fit
+	pods := make([]*corev1.Pod, len(dr.Pods))
+	copy(pods, dr.Pods)
+	podReqs := make(map[*corev1.Pod]corev1.ResourceList, len(pods))
+	for _, pod := range pods {
+		podReqs[pod], _ = utils.PodRequestsAndLimits(pod)
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		ri, rj := podReqs[pods[i]], podReqs[pods[j]]
+		if c := ri.Cpu().Cmp(*rj.Cpu()); c != 0 {
+			return c > 0
+		}
+		return ri.Memory().Cmp(*rj.Memory()) > 0
+	})
+
+	for _, pod := range pods {
+		reqs := podReqs[pod]
+		pp := PodPlacement{
+			PodName:    pod.Name,
+			Namespace:  pod.Namespace,
+			CpuRequest: reqs.Cpu().String(),
+			MemRequest: reqs.Memory().String(),
+			Requests:   reqs,
+		}
+
+		var target *nodeCapacity
+		reasons := reasonCounter{}
+		for _, nc := range nodes {
+			if failed := checkPredicates(pod, nc.node); len(failed) != 0 {
+				reasons.add(failed)
+				continue
+			}
+			pp.EligibleNodes++
+			if insufficient := nc.fits(reqs); len(insufficient) != 0 {
+				reasons.add(insufficient)
+				continue
+			}
+			pp.FitNodes++
+			if target == nil || nc.freeScore(reqs) > target.freeScore(reqs) {
+				target = nc
+			}
+		}
+
+		if target != nil {
+			target.add(reqs)
+			pp.TargetNode = target.node.Name
+		} else {
+			pp.Reasons = reasons.list()
+		}
+		dr.PodPlacements = append(dr.PodPlacements, pp)
+	}
+
+	for _, nc := range nodes {
+		if schedule(nc.node) || nc.newPods != 0 {
+			dr.NodeUtilizations = append(dr.NodeUtilizations, nc.utilization())
+		}
+	}
+
+	return nil
+}
+
+// fits returns the reasons the requests don't fit into the unrequested
+// resources of the node, none if they fit. Every requested resource counts,
+// resources a node doesn't advertise are not available on it at all.
+func (nc *nodeCapacity) fits(reqs corev1.ResourceList) []string {
+	var reasons []string
+	if nc.maxPods != 0 && uint(nc.pods+nc.newPods) >= nc.maxPods {
+		reasons = append(reasons, REASON_TOO_MANY_PODS)
+	}
+	for _, name := range resourceNames(reqs) {
+		req, ok := reqs[name]
+		if !ok || req.IsZero() {
+			continue
+		}
+		allocatable := nc.allocatable[name]
+		requested := nc.requested[name].DeepCopy()
+		requested.Add(req)
+		if requested.Cmp(allocatable) > 0 {
+			reasons = append(reasons, fmt.Sprintf(REASON_INSUFFICIENT, name))
+		}
+	}
+	return reasons
+}
+
+// freeScore is the average share of cpu and memory left on the node once the
+// requests are placed on it. Like the default scheduler, placement prefers the
+// least requested node.
+func (nc *nodeCapacity) freeScore(reqs corev1.ResourceList) int64 {
+	var score int64
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		requested := nc.requested[name].DeepCopy()
+		requested.Add(reqs[name])
+		score += 100 - percent(requested, nc.allocatable[name])
+	}
+	return score / 2
+}
+
+func (nc *nodeCapacity) add(reqs corev1.ResourceList) {
+	for name, quantity := range reqs {
+		if value, ok := nc.requested[name]; !ok {
+			nc.requested[name] = quantity.DeepCopy()
+		} else {
+			value.Add(quantity)
+			nc.requested[name] = value
+		}
+	}
+	nc.newPods++
+}
+
+func (nc *nodeCapacity) utilization() NodeUtilization {
+	cpuAllocatable, memAllocatable := nc.allocatable[corev1.ResourceCPU], nc.allocatable[corev1.ResourceMemory]
+	cpuRequested, memRequested := nc.requested[corev1.ResourceCPU], nc.requested[corev1.ResourceMemory]
+
+	nu := NodeUtilization{
+		NodeName:       nc.node.Name,
+		CpuAllocatable: cpuAllocatable.String(),
+		MemAllocatable: memAllocatable.String(),
+		CpuRequested:   cpuRequested.String(),
+		MemRequested:   memRequested.String(),
+		CpuPercent:     percent(cpuRequested, cpuAllocatable),
+		MemPercent:     percent(memRequested, memAllocatable),
+		Allocatable:    nc.allocatable,
+		Requested:      nc.requested,
+		NewPods:        nc.newPods,
+		MaxPods:        nc.maxPods,
+		Pods:           nc.pods + nc.newPods,
+	}
+	if nc.maxPods != 0 {
+		headroom := int(nc.maxPods) - nu.Pods
+		nu.PodHeadroom = &headroom
+	}
+	return nu
+}
+
+func percent(requested, allocatable resource.Quantity) int64 {
+	if allocatable.IsZero() {
+		return 100
+	}
+	return requested.MilliValue() * 100 / allocatable.MilliValue()
+}
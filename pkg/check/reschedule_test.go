@@ -0,0 +1,125 @@
+package check
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestRescheduleHeadroom(t *testing.T) {
+	unknown := newNode("node-3", nil, "4", "8Gi")
+	delete(unknown.Status.Allocatable, corev1.ResourcePods)
+	pod := newPod("default", "web-1", "node-1", REPLICASET_WORKLOAD, "web", "100m", "64Mi")
+	snap := newTestSnapshot(t,
+		newNode("node-1", nil, "4", "8Gi"),
+		newNode("node-2", nil, "4", "8Gi"),
+		unknown,
+		pod,
+	)
+
+	dr := NewDetectReschedule([]string{"node-1"}, snap, []*corev1.Pod{pod})
+	if err := dr.Detect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	headrooms := make(map[string]*int)
+	for _, nu := range dr.NodeUtilizations {
+		headrooms[nu.NodeName] = nu.PodHeadroom
+	}
+	if headroom := headrooms["node-2"]; headroom == nil || *headroom < 109 {
+		t.Errorf("expected node-2 to have pod headroom left, got %v", headroom)
+	}
+	if headroom, ok := headrooms["node-3"]; !ok || headroom != nil {
+		t.Errorf("expected no pod headroom for node-3 of unknown capacity, got %v", headroom)
+	}
+}
+
+func TestReschedulePlacement(t *testing.T) {
+	withRequest := func(pod *corev1.Pod, name corev1.ResourceName, quantity string) *corev1.Pod {
+		pod.Spec.Containers[0].Resources.Requests[name] = resource.MustParse(quantity)
+		return pod
+	}
+	withAllocatable := func(node *corev1.Node, name corev1.ResourceName, quantity string) *corev1.Node {
+		node.Status.Allocatable[name] = resource.MustParse(quantity)
+		return node
+	}
+
+	tests := []struct {
+		name    string
+		node    *corev1.Node
+		running []runtime.Object
+		pod     *corev1.Pod
+		target  string
+		fit     int
+		reasons []string
+	}{
+		{
+			name:   "fits on cpu, memory and ephemeral-storage",
+			node:   withAllocatable(newNode("node-2", nil, "4", "8Gi"), corev1.ResourceEphemeralStorage, "10Gi"),
+			pod:    withRequest(newPod("default", "web-a", "node-1", REPLICASET_WORKLOAD, "web", "100m", "64Mi"), corev1.ResourceEphemeralStorage, "2Gi"),
+			target: "node-2",
+			fit:    1,
+		},
+		{
+			name:    "fits on cpu but not on ephemeral-storage",
+			node:    withAllocatable(newNode("node-2", nil, "4", "8Gi"), corev1.ResourceEphemeralStorage, "1Gi"),
+			pod:     withRequest(newPod("default", "web-a", "node-1", REPLICASET_WORKLOAD, "web", "100m", "64Mi"), corev1.ResourceEphemeralStorage, "2Gi"),
+			reasons: []string{"1 Insufficient ephemeral-storage"},
+		},
+		{
+			name: "ephemeral-storage requested by running pods is taken",
+			node: withAllocatable(newNode("node-2", nil, "4", "8Gi"), corev1.ResourceEphemeralStorage, "3Gi"),
+			running: []runtime.Object{
+				withRequest(newPod("default", "cache-a", "node-2", REPLICASET_WORKLOAD, "cache", "100m", "64Mi"), corev1.ResourceEphemeralStorage, "2Gi"),
+			},
+			pod:     withRequest(newPod("default", "web-a", "node-1", REPLICASET_WORKLOAD, "web", "100m", "64Mi"), corev1.ResourceEphemeralStorage, "2Gi"),
+			reasons: []string{"1 Insufficient ephemeral-storage"},
+		},
+		{
+			name:    "resources the node doesn't advertise are not available",
+			node:    newNode("node-2", nil, "4", "8Gi"),
+			pod:     withRequest(newPod("default", "train-a", "node-1", REPLICASET_WORKLOAD, "train", "100m", "64Mi"), "nvidia.com/gpu", "1"),
+			reasons: []string{"1 Insufficient nvidia.com/gpu"},
+		},
+		{
+			name:    "cpu and memory are both reported",
+			node:    newNode("node-2", nil, "1", "1Gi"),
+			pod:     newPod("default", "web-a", "node-1", REPLICASET_WORKLOAD, "web", "2", "2Gi"),
+			reasons: []string{"1 Insufficient cpu", "1 Insufficient memory"},
+		},
+		{
+			name: "full nodes take no more pods",
+			node: withAllocatable(newNode("node-2", nil, "4", "8Gi"), corev1.ResourcePods, "1"),
+			running: []runtime.Object{
+				newPod("default", "cache-a", "node-2", REPLICASET_WORKLOAD, "cache", "100m", "64Mi"),
+			},
+			pod:     newPod("default", "web-a", "node-1", REPLICASET_WORKLOAD, "web", "100m", "64Mi"),
+			reasons: []string{"1 Too many pods"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := append([]runtime.Object{newNode("node-1", nil, "4", "8Gi"), test.node, test.pod}, test.running...)
+			dr := NewDetectReschedule([]string{"node-1"}, newTestSnapshot(t, objs...), []*corev1.Pod{test.pod})
+			if err := dr.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(dr.PodPlacements) != 1 {
+				t.Fatalf("expected one placement, got %+v", dr.PodPlacements)
+			}
+			pp := dr.PodPlacements[0]
+			if pp.TargetNode != test.target {
+				t.Errorf("expected target node %q, got %q", test.target, pp.TargetNode)
+			}
+			if pp.EligibleNodes != 1 || pp.FitNodes != test.fit {
+				t.Errorf("expected 1 eligible and %d fit nodes, got %d and %d", test.fit, pp.EligibleNodes, pp.FitNodes)
+			}
+			if !equalStrings(pp.Reasons, test.reasons) {
+				t.Errorf("expected reasons %v, got %v", test.reasons, pp.Reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package check
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+// isExtendedResource reports whether the resource is advertised by a device
+// plugin or the cluster administrator rather than being native to
+// kubernetes, e.g. nvidia.com/gpu.
+func isExtendedResource(name corev1.ResourceName) bool {
+	if !strings.Contains(string(name), "/") {
+		return false
+	}
+	return !strings.HasPrefix(string(name), corev1.ResourceDefaultNamespacePrefix) &&
+		!strings.HasPrefix(string(name), corev1.DefaultResourceRequestsPrefix)
+}
+
+// isGpuResource reports whether the extended resource is a GPU or a share
+// of one, device plugins name them e.g. nvidia.com/gpu or amd.com/gpu.
+func isGpuResource(name corev1.ResourceName) bool {
+	return isExtendedResource(name) && strings.Contains(strings.ToLower(string(name)), "gpu")
+}
+
+// resourceNames returns the resources of the list, sorted.
+func resourceNames(list corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// OtherResources returns the resources of the list besides cpu, memory and
+// the pod count: ephemeral-storage, hugepages-* and extended resources.
+func OtherResources(list corev1.ResourceList) corev1.ResourceList {
+	other := corev1.ResourceList{}
+	for name, quantity := range list {
+		switch name {
+		case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourcePods:
+			continue
+		}
+		other[name] = quantity
+	}
+	return other
+}
+
+// FormatResources formats resources as name=quantity pairs ordered by name.
+func FormatResources(list corev1.ResourceList) string {
+	pairs := make([]string, 0, len(list))
+	for _, name := range resourceNames(list) {
+		quantity := list[name]
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, quantity.String()))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -1 +1,137 @@
 package check
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+)
+
+type ServiceDetail struct {
+	ServiceName           string `json:"serviceName"`
+	Namespace             string `json:"namespace"`
+	Type                  string `json:"type"`
+	ExternalTrafficPolicy string `json:"externalTrafficPolicy,omitempty"`
+	ReadyEndpoints        int    `json:"readyEndpoints"`
+	// DrainEndpoints is the number of ready endpoints backed by pods on the drain nodes.
+	DrainEndpoints     int `json:"drainEndpoints"`
+	RemainingEndpoints int `json:"remainingEndpoints"`
+	// Outage is set when no ready endpoint is left after the drain.
+	Outage bool `json:"outage"`
+	// LocalTrafficDropped is set for services with externalTrafficPolicy
+	// Local, the load balancer traffic sent to the drain nodes is dropped.
+	LocalTrafficDropped bool              `json:"localTrafficDropped"`
+	Labels              map[string]string `json:"labels,omitempty"`
+}
+
+type DetectService struct {
+	DrainNodes []string
+	Snapshot   *snapshot.Snapshot
+	// Index is shared with the other checkers of the snapshot, Detect derives
+	// its own if nil.
+	Index          *Index
+	ServiceDetails []ServiceDetail
+}
+
+func NewDetectService(drainNodes []string, snap *snapshot.Snapshot) *DetectService {
+	return &DetectService{
+		DrainNodes:     drainNodes,
+		Snapshot:       snap,
+		ServiceDetails: []ServiceDetail{},
+	}
+}
+
+func (ds *DetectService) Detect() error {
+	si := orNewIndex(ds.Index, ds.Snapshot, nil).serviceIndex()
+
+	// only services backed by the drain nodes matter
+	backed := make(map[int]bool)
+	for _, drainNode := range ds.DrainNodes {
+		for _, i := range si.nodeServices[drainNode] {
+			backed[i] = true
+		}
+	}
+	services := make([]int, 0, len(backed))
+	for i := range backed {
+		services = append(services, i)
+	}
+	sort.Ints(services)
+
+	for _, i := range services {
+		svc := si.services[i].service
+		ready, drain := len(si.services[i].nodes), 0
+		for _, nodeName := range si.services[i].nodes {
+			if isDrainNode(ds.DrainNodes, nodeName) {
+				drain++
+			}
+		}
+
+		sd := ServiceDetail{
+			ServiceName:           svc.Name,
+			Namespace:             svc.Namespace,
+			Type:                  string(svc.Spec.Type),
+			ExternalTrafficPolicy: string(svc.Spec.ExternalTrafficPolicy),
+			ReadyEndpoints:        ready,
+			DrainEndpoints:        drain,
+			RemainingEndpoints:    ready - drain,
+			Outage:                ready-drain == 0,
+			LocalTrafficDropped:   svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal,
+			Labels:                svc.Labels,
+		}
+		ds.ServiceDetails = append(ds.ServiceDetails, sd)
+	}
+
+	return nil
+}
+
+// newServiceIndex resolves the nodes of the ready endpoints of every service.
+func newServiceIndex(snap *snapshot.Snapshot) *serviceIndex {
+	si := &serviceIndex{nodeServices: make(map[string][]int)}
+	for _, svc := range snap.Services {
+		ep := snap.EndpointsOf(svc.Namespace, svc.Name)
+		if ep == nil {
+			continue
+		}
+
+		se := serviceEndpoints{service: svc, nodes: readyEndpointNodes(snap, ep)}
+		for _, nodeName := range se.nodes {
+			services := si.nodeServices[nodeName]
+			if len(services) == 0 || services[len(services)-1] != len(si.services) {
+				si.nodeServices[nodeName] = append(services, len(si.services))
+			}
+		}
+		si.services = append(si.services, se)
+	}
+	return si
+}
+
+// readyEndpointNodes returns the node of each ready endpoint address. An
+// address listed in several subsets, one per port set, is counted once.
+func readyEndpointNodes(snap *snapshot.Snapshot, ep *corev1.Endpoints) []string {
+	var nodes []string
+	ready := make(map[string]bool)
+	for _, subset := range ep.Subsets {
+		for _, address := range subset.Addresses {
+			if ready[address.IP] {
+				continue
+			}
+			ready[address.IP] = true
+			nodes = append(nodes, endpointNodeName(snap, ep.Namespace, address))
+		}
+	}
+	return nodes
+}
+
+func endpointNodeName(snap *snapshot.Snapshot, ns string, address corev1.EndpointAddress) string {
+	if address.NodeName != nil {
+		return *address.NodeName
+	}
+	if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return ""
+	}
+
+	pod := snap.Pod(ns, address.TargetRef.Name)
+	if pod == nil {
+		return ""
+	}
+	return pod.Spec.NodeName
+}
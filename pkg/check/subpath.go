@@ -1,2 +1,165 @@
 package check
 
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"path"
+	"strings"
+)
+
+const (
+	VOLUME_EMPTYDIR = "emptyDir"
+	VOLUME_HOSTPATH = "hostPath"
+
+	MEDIUM_DISK   = "Disk"
+	MEDIUM_MEMORY = "Memory"
+)
+
+type VolumeDetail struct {
+	VolumeName string `json:"volumeName"`
+	VolumeType string `json:"volumeType"`
+	// Medium and SizeLimit describe emptyDir volumes.
+	Medium    string `json:"medium,omitempty"`
+	SizeLimit string `json:"sizeLimit,omitempty"`
+	// Path and HostPathType describe hostPath volumes.
+	Path         string `json:"path,omitempty"`
+	HostPathType string `json:"hostPathType,omitempty"`
+	// SubPaths lists the subPath mounts of the volume as container:subPath.
+	SubPaths []string `json:"subPaths,omitempty"`
+	DataLoss string   `json:"dataLoss"`
+}
+
+type StorageDetail struct {
+	PodName   string         `json:"podName"`
+	Namespace string         `json:"namespace"`
+	NodeName  string         `json:"nodeName"`
+	Volumes   []VolumeDetail `json:"volumes"`
+}
+
+type DetectStorage struct {
+	DrainNodes     []string
+	Snapshot       *snapshot.Snapshot
+	StorageDetails []StorageDetail
+}
+
+func NewDetectStorage(drainNodes []string, snap *snapshot.Snapshot) *DetectStorage {
+	return &DetectStorage{
+		DrainNodes:     drainNodes,
+		Snapshot:       snap,
+		StorageDetails: []StorageDetail{},
+	}
+}
+
+func (ds *DetectStorage) Detect() error {
+	for _, drainNode := range ds.DrainNodes {
+		for _, pod := range ds.Snapshot.NodePods(drainNode) {
+			// DaemonSet and mirror pods are not evicted, their data stays
+			if !isEvictable(newPodDetail(ds.Snapshot, pod)) {
+				continue
+			}
+
+			volumes := getLocalVolumes(pod)
+			if len(volumes) == 0 {
+				continue
+			}
+			ds.StorageDetails = append(ds.StorageDetails, StorageDetail{
+				PodName:   pod.Name,
+				Namespace: pod.Namespace,
+				NodeName:  drainNode,
+				Volumes:   volumes,
+			})
+		}
+	}
+
+	return nil
+}
+
+// getLocalVolumes returns the emptyDir and hostPath volumes of the pod with
+// the data lost on eviction.
+func getLocalVolumes(pod *corev1.Pod) []VolumeDetail {
+	subPaths := getSubPathMounts(pod)
+
+	var volumes []VolumeDetail
+	for _, volume := range pod.Spec.Volumes {
+		vd := VolumeDetail{
+			VolumeName: volume.Name,
+		}
+		for _, mount := range subPaths[volume.Name] {
+			vd.SubPaths = append(vd.SubPaths, mount.container+":"+mount.subPath)
+		}
+
+		switch {
+		case volume.EmptyDir != nil:
+			vd.VolumeType = VOLUME_EMPTYDIR
+			vd.Medium = MEDIUM_DISK
+			if volume.EmptyDir.Medium == corev1.StorageMediumMemory {
+				vd.Medium = MEDIUM_MEMORY
+			}
+			if volume.EmptyDir.SizeLimit != nil {
+				vd.SizeLimit = volume.EmptyDir.SizeLimit.String()
+			}
+			vd.DataLoss = emptyDirDataLoss(vd)
+		case volume.HostPath != nil:
+			vd.VolumeType = VOLUME_HOSTPATH
+			vd.Path = volume.HostPath.Path
+			if volume.HostPath.Type != nil {
+				vd.HostPathType = string(*volume.HostPath.Type)
+			}
+			vd.DataLoss = hostPathDataLoss(vd.Path, subPaths[volume.Name], pod.Spec.NodeName)
+		default:
+			continue
+		}
+
+		volumes = append(volumes, vd)
+	}
+	return volumes
+}
+
+type subPathMount struct {
+	container string
+	subPath   string
+}
+
+// getSubPathMounts maps volume names to their subPath mounts.
+func getSubPathMounts(pod *corev1.Pod) map[string][]subPathMount {
+	subPaths := make(map[string][]subPathMount)
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, mount := range container.VolumeMounts {
+			subPath := mount.SubPath
+			if subPath == "" {
+				subPath = mount.SubPathExpr
+			}
+			if subPath == "" {
+				continue
+			}
+			subPaths[mount.Name] = append(subPaths[mount.Name], subPathMount{container: container.Name, subPath: subPath})
+		}
+	}
+	return subPaths
+}
+
+func emptyDirDataLoss(vd VolumeDetail) string {
+	size := "unbounded"
+	if vd.SizeLimit != "" {
+		size = "up to " + vd.SizeLimit
+	}
+	if vd.Medium == MEDIUM_MEMORY {
+		return fmt.Sprintf("tmpfs contents (%s) are lost", size)
+	}
+	return fmt.Sprintf("node disk contents (%s) are deleted", size)
+}
+
+// hostPathDataLoss names the host directories the pod wrote to. With subPath
+// mounts only the mounted sub directories are used.
+func hostPathDataLoss(hostPath string, mounts []subPathMount, nodeName string) string {
+	paths := []string{hostPath}
+	if len(mounts) != 0 {
+		paths = paths[:0]
+		for _, mount := range mounts {
+			paths = append(paths, path.Join(hostPath, mount.subPath))
+		}
+	}
+	return fmt.Sprintf("files under %s stay on node %s and are not available to the rescheduled pod", strings.Join(paths, ", "), nodeName)
+}
@@ -0,0 +1,33 @@
+package check
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func TestDetectStorage(t *testing.T) {
+	withHostPath := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Spec.Volumes = []corev1.Volume{{
+			Name:         "data",
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/lib/data"}},
+		}}
+		return pod
+	}
+
+	ds := NewDetectStorage([]string{"node-1"}, newTestSnapshot(t,
+		withHostPath(newPod("default", "db-0", "node-1", STATEFULSET_WORKLOAD, "db", "100m", "64Mi")),
+		withHostPath(newPod("kube-system", "logs-a", "node-1", DAEMONSET_WORKLOAD, "logs", "100m", "64Mi")),
+		withHostPath(newPod("kube-system", "etcd-node-1", "node-1", NODE_OWNER, "node-1", "100m", "64Mi")),
+	))
+	if err := ds.Detect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the data of daemon set and mirror pods stays with them on the node
+	if len(ds.StorageDetails) != 1 || ds.StorageDetails[0].PodName != "db-0" {
+		t.Fatalf("expected only the storage of db-0, got %+v", ds.StorageDetails)
+	}
+	if volumes := ds.StorageDetails[0].Volumes; len(volumes) != 1 || volumes[0].VolumeType != VOLUME_HOSTPATH {
+		t.Errorf("expected the hostPath volume of db-0, got %+v", volumes)
+	}
+}
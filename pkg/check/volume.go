@@ -0,0 +1,180 @@
+package check
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// zoneLabels are the labels of zonal volumes which a node has to carry with
+// the same value to attach them.
+var zoneLabels = []string{
+	corev1.LabelZoneFailureDomain,
+	corev1.LabelZoneRegion,
+	corev1.LabelZoneFailureDomainStable,
+	corev1.LabelZoneRegionStable,
+}
+
+type ClaimDetail struct {
+	ClaimName  string `json:"claimName"`
+	VolumeName string `json:"volumeName,omitempty"`
+	Local      bool   `json:"local"`
+	// Topology describes where the volume can be attached, empty if anywhere.
+	Topology string `json:"topology,omitempty"`
+}
+
+type PodVolumeDetail struct {
+	PodName   string        `json:"podName"`
+	Namespace string        `json:"namespace"`
+	NodeName  string        `json:"nodeName"`
+	Claims    []ClaimDetail `json:"claims"`
+	// CandidateNodes are the nodes left to the pod once the scheduling
+	// predicates and the topology of its volumes are applied.
+	CandidateNodes []string `json:"candidateNodes"`
+	// Stuck is set when no node is left, the pod can never be rescheduled.
+	Stuck bool `json:"stuck"`
+}
+
+type DetectVolume struct {
+	DrainNodes       []string
+	Snapshot         *snapshot.Snapshot
+	Pods             []*corev1.Pod
+	PodVolumeDetails []PodVolumeDetail
+}
+
+func NewDetectVolume(drainNodes []string, snap *snapshot.Snapshot, pods []*corev1.Pod) *DetectVolume {
+	return &DetectVolume{
+		DrainNodes:       drainNodes,
+		Snapshot:         snap,
+		Pods:             pods,
+		PodVolumeDetails: []PodVolumeDetail{},
+	}
+}
+
+func (dv *DetectVolume) Detect() error {
+	for _, pod := range dv.Pods {
+		var claims []ClaimDetail
+		var pvs []*corev1.PersistentVolume
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			cd, pv := dv.getClaim(pod.Namespace, volume.PersistentVolumeClaim.ClaimName)
+			claims = append(claims, cd)
+			if pv != nil {
+				pvs = append(pvs, pv)
+			}
+		}
+		if len(claims) == 0 {
+			continue
+		}
+
+		pvd := PodVolumeDetail{
+			PodName:        pod.Name,
+			Namespace:      pod.Namespace,
+			NodeName:       pod.Spec.NodeName,
+			Claims:         claims,
+			CandidateNodes: []string{},
+		}
+		for _, node := range dv.Snapshot.Nodes {
+			if isDrainNode(dv.DrainNodes, node.Name) || len(checkPredicates(pod, node)) != 0 {
+				continue
+			}
+			if volumesTopologyMatches(pvs, node) {
+				pvd.CandidateNodes = append(pvd.CandidateNodes, node.Name)
+			}
+		}
+		pvd.Stuck = len(pvd.CandidateNodes) == 0
+
+		dv.PodVolumeDetails = append(dv.PodVolumeDetails, pvd)
+	}
+
+	return nil
+}
+
+// getClaim resolves the claim to its volume. An unbound or missing claim has
+// no volume yet and doesn't restrict the pod.
+func (dv *DetectVolume) getClaim(ns, claimName string) (ClaimDetail, *corev1.PersistentVolume) {
+	cd := ClaimDetail{
+		ClaimName: claimName,
+	}
+
+	pvc := dv.Snapshot.PersistentVolumeClaim(ns, claimName)
+	if pvc == nil || pvc.Spec.VolumeName == "" {
+		return cd, nil
+	}
+
+	pv := dv.Snapshot.PersistentVolume(pvc.Spec.VolumeName)
+	if pv == nil {
+		return cd, nil
+	}
+
+	cd.VolumeName = pv.Name
+	cd.Local = pv.Spec.Local != nil
+	cd.Topology = getVolumeTopology(pv)
+	return cd, pv
+}
+
+func volumesTopologyMatches(pvs []*corev1.PersistentVolume, node *corev1.Node) bool {
+	for _, pv := range pvs {
+		if !volumeTopologyMatches(pv, node) {
+			return false
+		}
+	}
+	return true
+}
+
+// volumeTopologyMatches reports whether the volume can be attached to the
+// node, by its node affinity and by the legacy zone labels.
+func volumeTopologyMatches(pv *corev1.PersistentVolume, node *corev1.Node) bool {
+	if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil {
+		if !nodeSelectorTermsMatch(pv.Spec.NodeAffinity.Required.NodeSelectorTerms, node) {
+			return false
+		}
+	}
+
+	for _, key := range zoneLabels {
+		value, ok := pv.Labels[key]
+		if !ok {
+			continue
+		}
+		// a regional volume lists its zones separated by "__"
+		matched := false
+		for _, zone := range strings.Split(value, "__") {
+			if node.Labels[key] == zone {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getVolumeTopology(pv *corev1.PersistentVolume) string {
+	var topology []string
+	if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil {
+		var terms []string
+		for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			selector, err := nodeSelectorRequirementsAsSelector(term.MatchExpressions)
+			if err != nil {
+				terms = append(terms, fmt.Sprintf("invalid term: %v", err))
+				continue
+			}
+			terms = append(terms, selector.String())
+		}
+		topology = append(topology, strings.Join(terms, " or "))
+	}
+
+	for _, key := range zoneLabels {
+		if value, ok := pv.Labels[key]; ok {
+			topology = append(topology, key+"="+value)
+		}
+	}
+
+	return strings.Join(topology, "; ")
+}
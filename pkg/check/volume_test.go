@@ -0,0 +1,130 @@
+package check
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestDetectVolume(t *testing.T) {
+	zone := corev1.LabelZoneFailureDomainStable
+	claim := func(name, volumeName string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+		}
+	}
+	zonalVolume := func(name, zones string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{zone: zones}}}
+	}
+	localVolume := func(name, nodeName string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{Local: &corev1.LocalVolumeSource{Path: "/mnt/disks/" + name}},
+				NodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "kubernetes.io/hostname", Operator: corev1.NodeSelectorOpIn, Values: []string{nodeName}}},
+					}},
+				}},
+			},
+		}
+	}
+	podWithClaims := func(name string, claims ...string) *corev1.Pod {
+		pod := newPod("default", name, "node-1", STATEFULSET_WORKLOAD, "db", "100m", "64Mi")
+		for _, claim := range claims {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name:         claim,
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			})
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name       string
+		objs       []runtime.Object
+		pod        *corev1.Pod
+		listed     bool
+		candidates []string
+		topology   string
+	}{
+		{
+			name: "pods without claims are not listed",
+			pod:  podWithClaims("web-a"),
+		},
+		{
+			name:       "zonal volumes keep the pod in their zone",
+			objs:       []runtime.Object{claim("data", "pv-a"), zonalVolume("pv-a", "a")},
+			pod:        podWithClaims("db-0", "data"),
+			listed:     true,
+			candidates: []string{"node-2"},
+			topology:   zone + "=a",
+		},
+		{
+			name:       "regional volumes attach in every listed zone",
+			objs:       []runtime.Object{claim("data", "pv-ab"), zonalVolume("pv-ab", "a__b")},
+			pod:        podWithClaims("db-0", "data"),
+			listed:     true,
+			candidates: []string{"node-2", "node-3"},
+			topology:   zone + "=a__b",
+		},
+		{
+			name:     "local volumes of the drain node leave the pod stuck",
+			objs:     []runtime.Object{claim("data", "local-1"), localVolume("local-1", "node-1")},
+			pod:      podWithClaims("db-0", "data"),
+			listed:   true,
+			topology: "kubernetes.io/hostname in (node-1)",
+		},
+		{
+			name:       "unbound claims don't restrict the pod",
+			objs:       []runtime.Object{claim("data", "")},
+			pod:        podWithClaims("db-0", "data"),
+			listed:     true,
+			candidates: []string{"node-2", "node-3"},
+		},
+		{
+			name:     "every volume has to be attachable",
+			objs:     []runtime.Object{claim("data", "pv-a"), zonalVolume("pv-a", "a"), claim("logs", "pv-b"), zonalVolume("pv-b", "b")},
+			pod:      podWithClaims("db-0", "data", "logs"),
+			listed:   true,
+			topology: zone + "=a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := append([]runtime.Object{
+				newNode("node-1", map[string]string{zone: "a", "kubernetes.io/hostname": "node-1"}, "4", "8Gi"),
+				newNode("node-2", map[string]string{zone: "a", "kubernetes.io/hostname": "node-2"}, "4", "8Gi"),
+				newNode("node-3", map[string]string{zone: "b", "kubernetes.io/hostname": "node-3"}, "4", "8Gi"),
+				test.pod,
+			}, test.objs...)
+			dv := NewDetectVolume([]string{"node-1"}, newTestSnapshot(t, objs...), []*corev1.Pod{test.pod})
+			if err := dv.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !test.listed {
+				if len(dv.PodVolumeDetails) != 0 {
+					t.Errorf("expected no volume details, got %+v", dv.PodVolumeDetails)
+				}
+				return
+			}
+			if len(dv.PodVolumeDetails) != 1 {
+				t.Fatalf("expected the volume details of %s, got %+v", test.pod.Name, dv.PodVolumeDetails)
+			}
+			pvd := dv.PodVolumeDetails[0]
+			if !equalStrings(pvd.CandidateNodes, test.candidates) {
+				t.Errorf("expected candidate nodes %v, got %v", test.candidates, pvd.CandidateNodes)
+			}
+			if pvd.Stuck != (len(test.candidates) == 0) {
+				t.Errorf("expected stuck %v, got %v", len(test.candidates) == 0, pvd.Stuck)
+			}
+			if pvd.Claims[0].Topology != test.topology {
+				t.Errorf("expected topology %q, got %q", test.topology, pvd.Claims[0].Topology)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package check
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+)
+
+type WorkloadDetail struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	Kind            string `json:"kind"`
+	DesiredReplicas int32  `json:"desiredReplicas"`
+	ReadyReplicas   int32  `json:"readyReplicas"`
+	// DrainReplicas is the number of pods of the workload on the drain nodes.
+	DrainReplicas int32 `json:"drainReplicas"`
+	// AvailableAfter is the number of ready replicas left once the pods on
+	// the drain nodes are evicted and before they are running elsewhere.
+	AvailableAfter int32 `json:"availableAfter"`
+	SingleReplica  bool  `json:"singleReplica"`
+	// AllOnDrainNodes is set when every ready replica runs on the drain nodes.
+	AllOnDrainNodes bool `json:"allOnDrainNodes"`
+	// Downtime is set when the workload has no ready replica left.
+	Downtime bool              `json:"downtime"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+type DetectWorkload struct {
+	DrainNodes      []string
+	Snapshot        *snapshot.Snapshot
+	WorkloadDetails []WorkloadDetail
+}
+
+func NewDetectWorkload(drainNodes []string, snap *snapshot.Snapshot) *DetectWorkload {
+	return &DetectWorkload{
+		DrainNodes:      drainNodes,
+		Snapshot:        snap,
+		WorkloadDetails: []WorkloadDetail{},
+	}
+}
+
+type workloadPods struct {
+	workload   WorkloadDetail
+	drain      int32
+	drainReady int32
+}
+
+func (dw *DetectWorkload) Detect() error {
+	workloads := make(map[string]*workloadPods)
+	for _, drainNode := range dw.DrainNodes {
+		for _, pod := range dw.Snapshot.NodePods(drainNode) {
+			owner := workloadOwner(dw.Snapshot, pod)
+			if owner == nil {
+				continue
+			}
+			switch owner.Kind {
+			case DEPLOYMENT_WORKLOAD, STATEFULSET_WORKLOAD, REPLICASET_WORKLOAD:
+			default:
+				continue
+			}
+
+			key := owner.Kind + "/" + pod.Namespace + "/" + owner.Name
+			wp, ok := workloads[key]
+			if !ok {
+				wp = &workloadPods{
+					workload: WorkloadDetail{Name: owner.Name, Namespace: pod.Namespace, Kind: owner.Kind},
+				}
+				workloads[key] = wp
+			}
+			wp.drain++
+			if isPodReady(pod) {
+				wp.drainReady++
+			}
+		}
+	}
+
+	for _, wp := range workloads {
+		wd, ok := dw.getWorkload(wp.workload)
+		if !ok {
+			continue
+		}
+		wd.DrainReplicas = wp.drain
+		wd.AvailableAfter = wd.ReadyReplicas - wp.drainReady
+		if wd.AvailableAfter < 0 {
+			wd.AvailableAfter = 0
+		}
+		wd.SingleReplica = wd.DesiredReplicas == 1
+		wd.AllOnDrainNodes = wp.drainReady != 0 && wp.drainReady >= wd.ReadyReplicas
+		wd.Downtime = wd.SingleReplica || wd.AvailableAfter == 0
+
+		dw.WorkloadDetails = append(dw.WorkloadDetails, wd)
+	}
+
+	sort.Slice(dw.WorkloadDetails, func(i, j int) bool {
+		wi, wj := dw.WorkloadDetails[i], dw.WorkloadDetails[j]
+		if wi.Namespace != wj.Namespace {
+			return wi.Namespace < wj.Namespace
+		}
+		if wi.Kind != wj.Kind {
+			return wi.Kind < wj.Kind
+		}
+		return wi.Name < wj.Name
+	})
+
+	return nil
+}
+
+// getWorkload fills in the replicas of the workload, false if it is not in
+// the snapshot.
+func (dw *DetectWorkload) getWorkload(wd WorkloadDetail) (WorkloadDetail, bool) {
+	switch wd.Kind {
+	case DEPLOYMENT_WORKLOAD:
+		deploy := dw.Snapshot.Deployment(wd.Namespace, wd.Name)
+		if deploy == nil {
+			return wd, false
+		}
+		wd.DesiredReplicas = desiredReplicas(deploy.Spec.Replicas)
+		wd.ReadyReplicas = deploy.Status.ReadyReplicas
+		wd.Labels = deploy.Labels
+	case STATEFULSET_WORKLOAD:
+		sts := dw.Snapshot.StatefulSet(wd.Namespace, wd.Name)
+		if sts == nil {
+			return wd, false
+		}
+		wd.DesiredReplicas = desiredReplicas(sts.Spec.Replicas)
+		wd.ReadyReplicas = sts.Status.ReadyReplicas
+		wd.Labels = sts.Labels
+	case REPLICASET_WORKLOAD:
+		rs := dw.Snapshot.ReplicaSet(wd.Namespace, wd.Name)
+		if rs == nil {
+			return wd, false
+		}
+		wd.DesiredReplicas = desiredReplicas(rs.Spec.Replicas)
+		wd.ReadyReplicas = rs.Status.ReadyReplicas
+		wd.Labels = rs.Labels
+	default:
+		return wd, false
+	}
+	return wd, true
+}
+
+// desiredReplicas defaults unset replicas to one like the API server does.
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
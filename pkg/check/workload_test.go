@@ -0,0 +1,75 @@
+package check
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"testing"
+)
+
+func TestDetectWorkload(t *testing.T) {
+	operatedSts := newStatefulSet("default", "db", 3, 3)
+	newCustomOwned(operatedSts, "Database", "db")
+	operatedPod := newPod("default", "job-a", "node-1", "", "", "100m", "64Mi")
+	newCustomOwned(operatedPod, "Task", "job")
+
+	tests := []struct {
+		name     string
+		objs     []runtime.Object
+		expected []WorkloadDetail
+	}{
+		{
+			name: "replica sets are reported as their deployment",
+			objs: []runtime.Object{
+				newDeployment("default", "web", 2, 2),
+				newReplicaSet("default", "web-1", "web"),
+				newPod("default", "web-1-a", "node-1", REPLICASET_WORKLOAD, "web-1", "100m", "64Mi"),
+				newPod("default", "web-1-b", "node-2", REPLICASET_WORKLOAD, "web-1", "100m", "64Mi"),
+			},
+			expected: []WorkloadDetail{{
+				Name: "web", Namespace: "default", Kind: DEPLOYMENT_WORKLOAD,
+				DesiredReplicas: 2, ReadyReplicas: 2, DrainReplicas: 1, AvailableAfter: 1,
+			}},
+		},
+		{
+			name: "stateful sets of custom resources are reported",
+			objs: []runtime.Object{
+				operatedSts,
+				newPod("default", "db-0", "node-1", STATEFULSET_WORKLOAD, "db", "100m", "64Mi"),
+				operatedPod,
+			},
+			expected: []WorkloadDetail{{
+				Name: "db", Namespace: "default", Kind: STATEFULSET_WORKLOAD,
+				DesiredReplicas: 3, ReadyReplicas: 3, DrainReplicas: 1, AvailableAfter: 2,
+			}},
+		},
+		{
+			name: "single replica workloads are down while drained",
+			objs: []runtime.Object{
+				newStatefulSet("default", "cache", 1, 1),
+				newPod("default", "cache-0", "node-1", STATEFULSET_WORKLOAD, "cache", "100m", "64Mi"),
+			},
+			expected: []WorkloadDetail{{
+				Name: "cache", Namespace: "default", Kind: STATEFULSET_WORKLOAD,
+				DesiredReplicas: 1, ReadyReplicas: 1, DrainReplicas: 1,
+				SingleReplica: true, AllOnDrainNodes: true, Downtime: true,
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dw := NewDetectWorkload([]string{"node-1"}, newTestSnapshot(t, test.objs...))
+			if err := dw.Detect(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(dw.WorkloadDetails) != len(test.expected) {
+				t.Fatalf("expected workloads %+v, got %+v", test.expected, dw.WorkloadDetails)
+			}
+			for i, expected := range test.expected {
+				if got := dw.WorkloadDetails[i]; !reflect.DeepEqual(got, expected) {
+					t.Errorf("expected workload %+v, got %+v", expected, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package drain
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"io"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Intervals of the eviction retries and the deletion polling, vars so the
+// tests can shorten them.
+var (
+	evictionBackoffInitial = time.Second
+	evictionBackoffMax     = 30 * time.Second
+	deletionPollInterval   = 2 * time.Second
+)
+
+// Target is a pod the drain evicts. Blocked pods were found by the analysis
+// to be unsafe to evict and are skipped unless the drain is forced.
+type Target struct {
+	PodName   string
+	Namespace string
+	NodeName  string
+	Blocked   bool
+	Reasons   []string
+}
+
+// TargetsFromReport classifies the pods of the report. DaemonSet and mirror
+// pods stay on the node and are never evicted. Pods the policy blocks, on
+// their own or through the disruption budget covering them, are blocked.
+func TargetsFromReport(r *report.DrainReport) []Target {
+	// workload reasons block the pods of the workload, the pods know their
+	// workload owner
+	ownerPods := make(map[string][]check.PodDetail)
+	for _, group := range [][]check.PodDetail{r.ReplicaSetPods, r.StatefulSetPods} {
+		for _, pod := range group {
+			key := pod.OwnerRefKind + "/" + pod.Namespace + "/" + pod.OwnerRef
+			ownerPods[key] = append(ownerPods[key], pod)
+		}
+	}
+
+	pdbPods := make(map[string][]check.PodDetail)
+	for _, pdb := range r.PodDisruptionBudgets {
+		pdbPods[pdb.PdbNamespace+"/"+pdb.PdbName] = pdb.PodDetails
+	}
+
+	blocking := make(map[string][]string)
+	for _, reason := range r.Reasons {
+		if reason.Severity != policy.SEVERITY_BLOCK {
+			continue
+		}
+		switch reason.Kind {
+		case report.KIND_POD:
+			key := reason.Namespace + "/" + reason.Name
+			blocking[key] = append(blocking[key], reason.Message)
+		case report.KIND_PDB:
+			for _, pod := range pdbPods[reason.Namespace+"/"+reason.Name] {
+				key := pod.Namespace + "/" + pod.PodName
+				blocking[key] = append(blocking[key], fmt.Sprintf("disruption budget %s: %s", reason.Name, reason.Message))
+			}
+		case check.DEPLOYMENT_WORKLOAD, check.STATEFULSET_WORKLOAD, check.REPLICASET_WORKLOAD:
+			for _, pod := range ownerPods[reason.Kind+"/"+reason.Namespace+"/"+reason.Name] {
+				key := pod.Namespace + "/" + pod.PodName
+				blocking[key] = append(blocking[key], fmt.Sprintf("%s %s: %s", reason.Kind, reason.Name, reason.Message))
+			}
+		}
+	}
+
+	var targets []Target
+	addTargets := func(pods []check.PodDetail) {
+		for _, pod := range pods {
+			target := Target{
+				PodName:   pod.PodName,
+				Namespace: pod.Namespace,
+				NodeName:  pod.NodeName,
+				Reasons:   blocking[pod.Namespace+"/"+pod.PodName],
+			}
+			target.Blocked = len(target.Reasons) != 0
+			targets = append(targets, target)
+		}
+	}
+	addTargets(r.ReplicaSetPods)
+	addTargets(r.StatefulSetPods)
+	for _, pod := range r.OtherPods {
+		// mirror pods belong to the kubelet and can't be evicted
+		if pod.OwnerRefKind != check.NODE_OWNER {
+			addTargets([]check.PodDetail{pod})
+		}
+	}
+	addTargets(r.IsolatedPods)
+
+	return targets
+}
+
+type Drainer struct {
+	Client  *utils.KubeCient
+	Out     io.Writer
+	Timeout time.Duration
+	Force   bool
+
+	lock sync.Mutex
+}
+
+func NewDrainer(client *utils.KubeCient, out io.Writer, timeout time.Duration, force bool) *Drainer {
+	return &Drainer{
+		Client:  client,
+		Out:     out,
+		Timeout: timeout,
+		Force:   force,
+	}
+}
+
+// Cordon marks the node unschedulable so no evicted pod comes back.
+func (d *Drainer) Cordon(nodeName string) error {
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	_, err := d.Client.ClientSet.CoreV1().Nodes().Patch(nodeName, types.StrategicMergePatchType, patch)
+	if err != nil {
+		klog.Errorf("Failed to cordon node %s: %v", nodeName, err)
+		return err
+	}
+	d.printf("node/%s cordoned\n", nodeName)
+	return nil
+}
+
+// Drain evicts all targets in parallel and waits until the pods are gone.
+// It gives up on every pod still pending once the timeout expired.
+func (d *Drainer) Drain(targets []Target) error {
+	deadline := time.Now().Add(d.Timeout)
+
+	var wg sync.WaitGroup
+	var failed []string
+	for _, target := range targets {
+		if target.Blocked && !d.Force {
+			d.printf("pod/%s skipped: %s\n", target.key(), strings.Join(target.Reasons, "; "))
+			continue
+		}
+
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			if err := d.evict(target, deadline); err != nil {
+				d.printf("pod/%s failed: %v\n", target.key(), err)
+				d.lock.Lock()
+				failed = append(failed, target.key())
+				d.lock.Unlock()
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to evict pods: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (d *Drainer) evict(target Target, deadline time.Time) error {
+	podClient := d.Client.ClientSet.CoreV1().Pods(target.Namespace)
+	pod, err := podClient.Get(target.PodName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		d.printf("pod/%s already gone\n", target.key())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+
+	d.printf("pod/%s evicting\n", target.key())
+	backoff := evictionBackoffInitial
+	for {
+		err = d.Client.ClientSet.PolicyV1beta1().Evictions(pod.Namespace).Evict(eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			break
+		}
+		if !apierrors.IsTooManyRequests(err) {
+			return err
+		}
+		// the disruption budget rejected the eviction, retry once other
+		// pods are running again
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("timed out waiting for the disruption budget: %v", err)
+		}
+		d.printf("pod/%s rejected by disruption budget, retrying in %s\n", target.key(), backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > evictionBackoffMax {
+			backoff = evictionBackoffMax
+		}
+	}
+	d.printf("pod/%s evicted\n", target.key())
+
+	for {
+		current, err := podClient.Get(pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+			d.printf("pod/%s deleted\n", target.key())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().Add(deletionPollInterval).After(deadline) {
+			return fmt.Errorf("timed out waiting for the pod to be deleted")
+		}
+		time.Sleep(deletionPollInterval)
+	}
+}
+
+func (d *Drainer) printf(format string, a ...interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	fmt.Fprintf(d.Out, format, a...)
+}
+
+func (t Target) key() string {
+	return t.Namespace + "/" + t.PodName
+}
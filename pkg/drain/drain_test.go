@@ -0,0 +1,169 @@
+package drain
+
+import (
+	"bytes"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var podsResource = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func TestTargetsFromReport(t *testing.T) {
+	r := &report.DrainReport{
+		ReplicaSetPods: []check.PodDetail{
+			{PodName: "web-1", Namespace: "default", OwnerRef: "web", OwnerRefKind: check.DEPLOYMENT_WORKLOAD},
+			{PodName: "api-1", Namespace: "default", OwnerRef: "api", OwnerRefKind: check.DEPLOYMENT_WORKLOAD},
+		},
+		StatefulSetPods: []check.PodDetail{
+			{PodName: "db-0", Namespace: "default", OwnerRef: "db", OwnerRefKind: check.STATEFULSET_WORKLOAD},
+		},
+		OtherPods: []check.PodDetail{
+			{PodName: "etcd-node-1", Namespace: "kube-system", OwnerRef: "node-1", OwnerRefKind: check.NODE_OWNER},
+			{PodName: "backup", Namespace: "default", OwnerRef: "backup", OwnerRefKind: check.JOB_WORKLOAD},
+		},
+		IsolatedPods: []check.PodDetail{
+			{PodName: "debug", Namespace: "default"},
+		},
+		PodDisruptionBudgets: []check.PdbDetail{
+			{PdbName: "db", PdbNamespace: "default", PodDetails: []check.PodDetail{{PodName: "db-0", Namespace: "default"}}},
+		},
+		Reasons: []report.Reason{
+			{Severity: policy.SEVERITY_BLOCK, Kind: report.KIND_POD, Namespace: "default", Name: "debug", Message: "pod is not recreated"},
+			{Severity: policy.SEVERITY_BLOCK, Kind: report.KIND_PDB, Namespace: "default", Name: "db", Message: "no disruption allowed"},
+			{Severity: policy.SEVERITY_BLOCK, Kind: check.DEPLOYMENT_WORKLOAD, Namespace: "default", Name: "web", Message: "single replica"},
+			// warnings never block
+			{Severity: policy.SEVERITY_WARN, Kind: check.DEPLOYMENT_WORKLOAD, Namespace: "default", Name: "api", Message: "all replicas on the drain nodes"},
+		},
+	}
+
+	expected := []Target{
+		{PodName: "web-1", Namespace: "default", Blocked: true, Reasons: []string{"Deployment web: single replica"}},
+		{PodName: "api-1", Namespace: "default"},
+		{PodName: "db-0", Namespace: "default", Blocked: true, Reasons: []string{"disruption budget db: no disruption allowed"}},
+		{PodName: "backup", Namespace: "default"},
+		{PodName: "debug", Namespace: "default", Blocked: true, Reasons: []string{"pod is not recreated"}},
+	}
+	if targets := TargetsFromReport(r); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("expected %+v, got %+v", expected, targets)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	defer shortenIntervals()()
+
+	tests := []struct {
+		name string
+		// rejections is the number of evictions rejected with 429 before
+		// one is accepted, negative rejects them all
+		rejections int
+		// deleted deletes the pod once the eviction is accepted
+		deleted  bool
+		force    bool
+		blocked  bool
+		evicted  bool
+		errorMsg string
+	}{
+		{name: "evicted", deleted: true, evicted: true},
+		{name: "retried after 429", rejections: 2, deleted: true, evicted: true},
+		{name: "429 until the deadline", rejections: -1, errorMsg: "failed to evict pods: default/web-1"},
+		{name: "not deleted until the deadline", errorMsg: "failed to evict pods: default/web-1"},
+		{name: "blocked is skipped", blocked: true, deleted: true},
+		{name: "blocked is evicted with force", blocked: true, force: true, deleted: true, evicted: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", UID: "web-1"},
+			})
+			var lock sync.Mutex
+			evictions := 0
+			clientSet.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				lock.Lock()
+				defer lock.Unlock()
+				evictions++
+				if test.rejections < 0 || evictions <= test.rejections {
+					return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+				}
+				if test.deleted {
+					eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+					if err := clientSet.Tracker().Delete(podsResource, eviction.Namespace, eviction.Name); err != nil {
+						return true, nil, err
+					}
+				}
+				return true, nil, nil
+			})
+
+			out := &bytes.Buffer{}
+			d := NewDrainer(&utils.KubeCient{ClientSet: clientSet}, out, 200*time.Millisecond, test.force)
+			target := Target{PodName: "web-1", Namespace: "default", NodeName: "node-1"}
+			if test.blocked {
+				target.Blocked = true
+				target.Reasons = []string{"single replica"}
+			}
+
+			err := d.Drain([]Target{target})
+			if test.errorMsg == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.errorMsg != "" && (err == nil || err.Error() != test.errorMsg) {
+				t.Fatalf("expected error %q, got %v", test.errorMsg, err)
+			}
+
+			if evicted := strings.Contains(out.String(), "pod/default/web-1 deleted"); evicted != test.evicted {
+				t.Errorf("expected evicted %v, got output:\n%s", test.evicted, out.String())
+			}
+			if expected := test.rejections + 1; test.rejections > 0 && evictions != expected {
+				t.Errorf("expected %d evictions, got %d", expected, evictions)
+			}
+			if skipped := strings.Contains(out.String(), "skipped: single replica"); skipped != (test.blocked && !test.force) {
+				t.Errorf("expected skipped %v, got output:\n%s", test.blocked && !test.force, out.String())
+			}
+			if test.blocked && !test.force && evictions != 0 {
+				t.Errorf("expected the blocked pod not to be evicted")
+			}
+		})
+	}
+}
+
+func TestCordon(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+	d := NewDrainer(&utils.KubeCient{ClientSet: clientSet}, &bytes.Buffer{}, time.Second, false)
+	if err := d.Cordon("node-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	node, err := clientSet.CoreV1().Nodes().Get("node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !node.Spec.Unschedulable {
+		t.Errorf("expected node-1 to be unschedulable")
+	}
+}
+
+// shortenIntervals makes the retries and polls fast, the returned func
+// restores them.
+func shortenIntervals() func() {
+	initial, max, poll := evictionBackoffInitial, evictionBackoffMax, deletionPollInterval
+	evictionBackoffInitial, evictionBackoffMax, deletionPollInterval = 10*time.Millisecond, 40*time.Millisecond, 10*time.Millisecond
+	return func() {
+		evictionBackoffInitial, evictionBackoffMax, deletionPollInterval = initial, max, poll
+	}
+}
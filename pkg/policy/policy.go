@@ -0,0 +1,222 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	POLICY_API_VERSION = "detectdrain.coderwangke.github.com/v1alpha1"
+	POLICY_KIND        = "DrainPolicy"
+)
+
+const (
+	SEVERITY_BLOCK  = "block"
+	SEVERITY_WARN   = "warn"
+	SEVERITY_IGNORE = "ignore"
+)
+
+// The checks a rule applies to. Each check finds objects of one kind in the
+// collected facts, the scope of the rule is matched against that object.
+const (
+	// pods
+	CHECK_ISOLATED_POD      = "IsolatedPod"
+	CHECK_UNSCHEDULABLE_POD = "UnschedulablePod"
+	CHECK_STUCK_VOLUME      = "StuckVolume"
+	CHECK_LOCAL_DATA_LOSS   = "LocalDataLoss"
+	CHECK_HOST_PATH         = "HostPath"
+	CHECK_ADDRESS_LOST      = "AddressLost"
+	CHECK_ADDRESS_PINNED    = "AddressPinned"
+	// workloads: Deployments, StatefulSets and ReplicaSets
+	CHECK_SINGLE_REPLICA       = "SingleReplica"
+	CHECK_ALL_REPLICAS_DRAINED = "AllReplicasDrained"
+	// disruption budgets
+	CHECK_PDB_BLOCKS_DRAIN = "PdbBlocksDrain"
+	CHECK_PDB_SLOWS_DRAIN  = "PdbSlowsDrain"
+	CHECK_INVALID_PDB      = "InvalidPdb"
+	// services
+	CHECK_SERVICE_OUTAGE     = "ServiceOutage"
+	CHECK_LOCAL_TRAFFIC_DROP = "LocalTrafficDropped"
+	// drain nodes
+	CHECK_GPU_NODE = "GpuNode"
+)
+
+var checks = map[string]bool{
+	CHECK_ISOLATED_POD:         true,
+	CHECK_UNSCHEDULABLE_POD:    true,
+	CHECK_STUCK_VOLUME:         true,
+	CHECK_LOCAL_DATA_LOSS:      true,
+	CHECK_HOST_PATH:            true,
+	CHECK_ADDRESS_LOST:         true,
+	CHECK_ADDRESS_PINNED:       true,
+	CHECK_SINGLE_REPLICA:       true,
+	CHECK_ALL_REPLICAS_DRAINED: true,
+	CHECK_PDB_BLOCKS_DRAIN:     true,
+	CHECK_PDB_SLOWS_DRAIN:      true,
+	CHECK_INVALID_PDB:          true,
+	CHECK_SERVICE_OUTAGE:       true,
+	CHECK_LOCAL_TRAFFIC_DROP:   true,
+	CHECK_GPU_NODE:             true,
+}
+
+// defaultPolicy reproduces the built-in verdict: everything making pods
+// unavailable for good blocks, everything degrading the drain warns.
+const defaultPolicy = `
+apiVersion: detectdrain.coderwangke.github.com/v1alpha1
+kind: DrainPolicy
+rules:
+- name: isolated-pod
+  check: IsolatedPod
+  severity: block
+- name: unschedulable-pod
+  check: UnschedulablePod
+  severity: block
+- name: stuck-volume
+  check: StuckVolume
+  severity: block
+- name: address-lost
+  check: AddressLost
+  severity: warn
+- name: address-pinned
+  check: AddressPinned
+  severity: block
+- name: single-replica
+  check: SingleReplica
+  severity: warn
+- name: all-replicas-drained
+  check: AllReplicasDrained
+  severity: warn
+- name: pdb-blocks-drain
+  check: PdbBlocksDrain
+  severity: block
+- name: pdb-slows-drain
+  check: PdbSlowsDrain
+  severity: warn
+- name: invalid-pdb
+  check: InvalidPdb
+  severity: warn
+- name: service-outage
+  check: ServiceOutage
+  severity: warn
+- name: local-traffic-dropped
+  check: LocalTrafficDropped
+  severity: warn
+- name: local-data-loss
+  check: LocalDataLoss
+  severity: warn
+`
+
+// Policy decides the severity of every finding of the checkers.
+type Policy struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Rules are matched in order, the first rule matching the check and the
+	// object decides. Findings no rule matches are ignored.
+	Rules []Rule `json:"rules"`
+}
+
+// Rule assigns a severity to the findings of a check within its scope. An
+// empty scope matches every object. Cluster scoped objects never match a
+// rule restricted to namespaces.
+type Rule struct {
+	Name       string   `json:"name"`
+	Check      string   `json:"check"`
+	Severity   string   `json:"severity"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Selector is a label selector on the labels of the object the finding is
+	// about, the pod, node, workload, budget or service details of the
+	// checkers carry them for it.
+	Selector string `json:"selector,omitempty"`
+
+	selector labels.Selector
+}
+
+// Default returns the built-in policy.
+func Default() *Policy {
+	p, err := Parse([]byte(defaultPolicy))
+	if err != nil {
+		panic(fmt.Sprintf("invalid default policy: %v", err))
+	}
+	return p
+}
+
+// Load reads the policy file, the built-in policy if path is empty.
+func Load(path string) (*Policy, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", path, err)
+	}
+	return p, nil
+}
+
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, err
+	}
+	if p.APIVersion != POLICY_API_VERSION || p.Kind != POLICY_KIND {
+		return nil, fmt.Errorf("unsupported policy %s %s, must be %s %s", p.APIVersion, p.Kind, POLICY_API_VERSION, POLICY_KIND)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if !checks[rule.Check] {
+			return nil, fmt.Errorf("rule %s: unknown check %q", rule.Name, rule.Check)
+		}
+		switch rule.Severity {
+		case SEVERITY_BLOCK, SEVERITY_WARN, SEVERITY_IGNORE:
+		default:
+			return nil, fmt.Errorf("rule %s: unknown severity %q, must be one of %s|%s|%s", rule.Name, rule.Severity, SEVERITY_BLOCK, SEVERITY_WARN, SEVERITY_IGNORE)
+		}
+
+		selector, err := labels.Parse(rule.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid selector: %v", rule.Name, err)
+		}
+		rule.selector = selector
+	}
+
+	return p, nil
+}
+
+// Match returns the rule deciding the finding of the check on the object,
+// nil if none does.
+func (p *Policy) Match(check, namespace string, objLabels map[string]string) *Rule {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Check == check && rule.matches(namespace, objLabels) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matches(namespace string, objLabels map[string]string) bool {
+	if len(r.Namespaces) != 0 {
+		found := false
+		for _, ns := range r.Namespaces {
+			if ns == namespace && namespace != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	// rules not built by Parse have no parsed selector and match any labels
+	return r.selector == nil || r.selector.Matches(labels.Set(objLabels))
+}
@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+apiVersion: detectdrain.coderwangke.github.com/v1alpha1
+kind: DrainPolicy
+rules:
+- name: batch-single-replica
+  check: SingleReplica
+  severity: ignore
+  namespaces: [batch]
+- name: critical-single-replica
+  check: SingleReplica
+  severity: block
+  selector: tier=critical
+- name: single-replica
+  check: SingleReplica
+  severity: warn
+- name: gpu-node
+  check: GpuNode
+  severity: block
+  namespaces: [default]
+`
+
+func TestMatch(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		check     string
+		namespace string
+		labels    map[string]string
+		// rule is the name of the matching rule, empty if none matches
+		rule string
+	}{
+		{name: "first match wins over a later selector", check: CHECK_SINGLE_REPLICA, namespace: "batch", labels: map[string]string{"tier": "critical"}, rule: "batch-single-replica"},
+		{name: "selector in scope", check: CHECK_SINGLE_REPLICA, namespace: "default", labels: map[string]string{"tier": "critical"}, rule: "critical-single-replica"},
+		{name: "selector out of scope", check: CHECK_SINGLE_REPLICA, namespace: "default", labels: map[string]string{"tier": "web"}, rule: "single-replica"},
+		{name: "no labels", check: CHECK_SINGLE_REPLICA, namespace: "default", rule: "single-replica"},
+		{name: "namespace out of scope", check: CHECK_GPU_NODE, namespace: "kube-system"},
+		{name: "cluster scoped object never matches namespaces", check: CHECK_GPU_NODE},
+		{name: "check without rule", check: CHECK_HOST_PATH, namespace: "default"},
+	}
+	for _, test := range tests {
+		rule := p.Match(test.check, test.namespace, test.labels)
+		name := ""
+		if rule != nil {
+			name = rule.Name
+		}
+		if name != test.rule {
+			t.Errorf("%s: expected rule %q, got %q", test.name, test.rule, name)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	header := "apiVersion: " + POLICY_API_VERSION + "\nkind: " + POLICY_KIND + "\n"
+	tests := []struct {
+		name     string
+		policy   string
+		errorMsg string
+	}{
+		{name: "unknown check", policy: header + "rules:\n- name: r\n  check: Unknown\n  severity: warn\n", errorMsg: `rule r: unknown check "Unknown"`},
+		{name: "unknown severity", policy: header + "rules:\n- name: r\n  check: HostPath\n  severity: fatal\n", errorMsg: `rule r: unknown severity "fatal"`},
+		{name: "missing name", policy: header + "rules:\n- check: HostPath\n  severity: warn\n", errorMsg: "rule 0 has no name"},
+		{name: "invalid selector", policy: header + "rules:\n- name: r\n  check: HostPath\n  severity: warn\n  selector: 'a b'\n", errorMsg: "rule r: invalid selector"},
+		{name: "unknown field", policy: header + "rules:\n- name: r\n  check: HostPath\n  level: warn\n", errorMsg: "unknown field"},
+		{name: "wrong kind", policy: "apiVersion: " + POLICY_API_VERSION + "\nkind: Policy\n", errorMsg: "unsupported policy"},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.policy))
+		if err == nil || !strings.Contains(err.Error(), test.errorMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.errorMsg, err)
+		}
+	}
+}
+
+// TestDefault checks the built-in policy keeps the severities of the checks
+// of the verdict before policies were configurable.
+func TestDefault(t *testing.T) {
+	p, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		CHECK_ISOLATED_POD:       SEVERITY_BLOCK,
+		CHECK_UNSCHEDULABLE_POD:  SEVERITY_BLOCK,
+		CHECK_STUCK_VOLUME:       SEVERITY_BLOCK,
+		CHECK_PDB_BLOCKS_DRAIN:   SEVERITY_BLOCK,
+		CHECK_PDB_SLOWS_DRAIN:    SEVERITY_WARN,
+		CHECK_INVALID_PDB:        SEVERITY_WARN,
+		CHECK_SERVICE_OUTAGE:     SEVERITY_WARN,
+		CHECK_LOCAL_TRAFFIC_DROP: SEVERITY_WARN,
+		CHECK_LOCAL_DATA_LOSS:    SEVERITY_WARN,
+	}
+	for check, severity := range expected {
+		for _, namespace := range []string{"", "default"} {
+			rule := p.Match(check, namespace, map[string]string{"app": "web"})
+			if rule == nil {
+				t.Errorf("expected a rule for %s in namespace %q", check, namespace)
+				continue
+			}
+			if rule.Severity != severity {
+				t.Errorf("expected %s to %s, got %s", check, severity, rule.Severity)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package report
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+)
+
+// Assess runs all checkers against the drain nodes of the indexed snapshot
+// and evaluates the results with the policy. Errors are failures of the
+// checkers reading the snapshot.
+func Assess(drainNodes []string, index *check.Index, p *policy.Policy) (*DrainReport, error) {
+	snap, matchers := index.Snapshot, index.Matchers
+	dnpClient := check.NewDetectNodePod(drainNodes, snap)
+	err := dnpClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	dnClient := check.NewDetectNode(drainNodes, snap, matchers)
+	dnClient.Index = index
+	err = dnClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	rsClient := check.NewDetectReschedule(drainNodes, snap, dnpClient.EvictablePods)
+	rsClient.Index = index
+	err = rsClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	workloadClient := check.NewDetectWorkload(drainNodes, snap)
+	err = workloadClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	pdbClient := check.NewDetectPdb(drainNodes, snap)
+	pdbClient.Index = index
+	err = pdbClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	svcClient := check.NewDetectService(drainNodes, snap)
+	svcClient.Index = index
+	err = svcClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	storageClient := check.NewDetectStorage(drainNodes, snap)
+	err = storageClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	volumeClient := check.NewDetectVolume(drainNodes, snap, dnpClient.EvictablePods)
+	err = volumeClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	addressClient := check.NewDetectAddress(drainNodes, snap, matchers)
+	addressClient.Index = index
+	err = addressClient.Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	drainReport := NewDrainReport(drainNodes, dnpClient, dnClient, rsClient, workloadClient, pdbClient, svcClient, storageClient, volumeClient, addressClient)
+	drainReport.Evaluate(p)
+	return drainReport, nil
+}
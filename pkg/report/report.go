@@ -0,0 +1,115 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"sigs.k8s.io/yaml"
+	"sort"
+)
+
+const (
+	REPORT_API_VERSION = "detectdrain.coderwangke.github.com/v1alpha1"
+	REPORT_KIND        = "DrainReport"
+)
+
+const (
+	OUTPUT_TEXT = "text"
+	OUTPUT_JSON = "json"
+	OUTPUT_YAML = "yaml"
+)
+
+// DrainReport is the versioned document describing the impact of draining a
+// group of nodes together.
+type DrainReport struct {
+	APIVersion                  string                  `json:"apiVersion"`
+	Kind                        string                  `json:"kind"`
+	DrainNodes                  []string                `json:"drainNodes"`
+	Verdict                     string                  `json:"verdict"`
+	Reasons                     []Reason                `json:"reasons"`
+	ReplicaSetPods              []check.PodDetail       `json:"replicaSetPods"`
+	StatefulSetPods             []check.PodDetail       `json:"statefulSetPods"`
+	DaemonSetPods               []check.PodDetail       `json:"daemonSetPods"`
+	OtherPods                   []check.PodDetail       `json:"otherPods"`
+	IsolatedPods                []check.PodDetail       `json:"isolatedPods"`
+	Nodes                       []check.NodeDetail      `json:"nodes"`
+	PodPlacements               []check.PodPlacement    `json:"podPlacements"`
+	DestinationNodes            []check.NodeUtilization `json:"destinationNodes"`
+	Workloads                   []check.WorkloadDetail  `json:"workloads"`
+	PodDisruptionBudgets        []check.PdbDetail       `json:"podDisruptionBudgets"`
+	Services                    []check.ServiceDetail   `json:"services"`
+	LocalStorage                []check.StorageDetail   `json:"localStorage"`
+	PersistentVolumes           []check.PodVolumeDetail `json:"persistentVolumes"`
+	Addresses                   []check.AddressDetail   `json:"addresses"`
+	InvalidPodDisruptionBudgets []check.PdbDetail       `json:"invalidPodDisruptionBudgets,omitempty"`
+}
+
+func NewDrainReport(drainNodes []string, dnp *check.DetectNodePod, dn *check.DetectNode, dr *check.DetectReschedule, dw *check.DetectWorkload, dp *check.DetectPdb, ds *check.DetectService, dst *check.DetectStorage, dv *check.DetectVolume, da *check.DetectAddress) *DrainReport {
+	return &DrainReport{
+		APIVersion:                  REPORT_API_VERSION,
+		Kind:                        REPORT_KIND,
+		DrainNodes:                  drainNodes,
+		ReplicaSetPods:              flattenPodDetails(dnp.PodDetails),
+		StatefulSetPods:             flattenPodDetails(dnp.StsPodDetails),
+		DaemonSetPods:               flattenPodDetails(dnp.DaemonSetPodDetails),
+		OtherPods:                   flattenPodDetails(dnp.OtherPodDetails),
+		IsolatedPods:                dnp.IsolatedPods,
+		Nodes:                       dn.NodeDetails,
+		PodPlacements:               dr.PodPlacements,
+		DestinationNodes:            dr.NodeUtilizations,
+		Workloads:                   dw.WorkloadDetails,
+		PodDisruptionBudgets:        dp.PdbDetails,
+		InvalidPodDisruptionBudgets: dp.InvalidPdbs,
+		Services:                    ds.ServiceDetails,
+		LocalStorage:                dst.StorageDetails,
+		PersistentVolumes:           dv.PodVolumeDetails,
+		Addresses:                   da.AddressDetails,
+	}
+}
+
+// flattenPodDetails turns the pods grouped by owner into a list ordered by
+// owner, so the output is the same on every run.
+func flattenPodDetails(grouped map[string][]check.PodDetail) []check.PodDetail {
+	owners := make([]string, 0, len(grouped))
+	for owner := range grouped {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	podDetails := []check.PodDetail{}
+	for _, owner := range owners {
+		podDetails = append(podDetails, grouped[owner]...)
+	}
+	return podDetails
+}
+
+func ValidOutput(output string) error {
+	switch output {
+	case OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_YAML:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, must be one of %s|%s|%s", output, OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_YAML)
+	}
+}
+
+// Render formats the report as text, json or yaml.
+func (r *DrainReport) Render(output string) (string, error) {
+	switch output {
+	case OUTPUT_TEXT:
+		return r.text()
+	case OUTPUT_JSON:
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	case OUTPUT_YAML:
+		data, err := yaml.Marshal(r)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	default:
+		return "", ValidOutput(output)
+	}
+}
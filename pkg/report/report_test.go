@@ -0,0 +1,202 @@
+package report
+
+import (
+	"flag"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestRender(t *testing.T) {
+	drainReport := newTestReport(t)
+
+	for _, output := range []string{OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_YAML} {
+		t.Run(output, func(t *testing.T) {
+			rendered, err := drainReport.Render(output)
+			if err != nil {
+				t.Fatalf("failed to render the report: %v", err)
+			}
+
+			golden := filepath.Join("testdata", "report."+output+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(rendered), 0644); err != nil {
+					t.Fatalf("failed to update %s: %v", golden, err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", golden, err)
+			}
+			if rendered != string(expected) {
+				t.Errorf("report differs from %s, rerun with -update if the change is intended:\n%s", golden, rendered)
+			}
+		})
+	}
+}
+
+// newTestReport assesses draining node-1 of the test cluster.
+func newTestReport(t *testing.T) *DrainReport {
+	t.Helper()
+	drainReport, err := Assess([]string{"node-1"}, newTestIndex(t), policy.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return drainReport
+}
+
+func TestAssessSharedIndex(t *testing.T) {
+	shared := newTestIndex(t)
+	// node-1 twice, the assessments must not modify the shared index
+	for _, node := range []string{"node-1", "node-2", "node-1"} {
+		expected, err := Assess([]string{node}, newTestIndex(t), policy.Default())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := Assess([]string{node}, shared, policy.Default())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expectedJSON, _ := expected.Render(OUTPUT_JSON)
+		gotJSON, _ := got.Render(OUTPUT_JSON)
+		if gotJSON != expectedJSON {
+			t.Errorf("assessment of %s with the shared index differs:\n%s\nexpected:\n%s", node, gotJSON, expectedJSON)
+		}
+	}
+}
+
+// newTestIndex indexes a small fake cluster: a deployment on node-1 whose
+// budget allows no disruption, a bare pod and a service served by the pods on
+// node-1 only.
+func newTestIndex(t *testing.T) *check.Index {
+	t.Helper()
+	replicas := int32(2)
+	minAvailable := intstr.FromInt(2)
+	objs := []runtime.Object{
+		newNode("node-1"),
+		newNode("node-2"),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: replicas},
+		},
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-1",
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{newControllerRef("Deployment", "web")},
+			},
+		},
+		newPod("web-1-a", "ReplicaSet", "web-1"),
+		newPod("web-1-b", "ReplicaSet", "web-1"),
+		newPod("debug", "", ""),
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				MinAvailable: &minAvailable,
+				Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+		},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{
+					{IP: "10.0.0.1", NodeName: stringPtr("node-1"), TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1-a"}},
+					{IP: "10.0.0.2", NodeName: stringPtr("node-1"), TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1-b"}},
+				},
+			}},
+		},
+	}
+
+	client := &utils.KubeCient{
+		ClientSet:     fake.NewSimpleClientset(objs...),
+		DynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		RESTMapper:    meta.NewDefaultRESTMapper(nil),
+	}
+	snap, err := snapshot.New(client)
+	if err != nil {
+		t.Fatalf("failed to snapshot the fake cluster: %v", err)
+	}
+	matchers, err := check.LoadAddressMatchers("")
+	if err != nil {
+		t.Fatalf("failed to load the default address matchers: %v", err)
+	}
+
+	return check.NewIndex(snap, matchers)
+}
+
+func newNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("8Gi"),
+				corev1.ResourcePods:   resource.MustParse("110"),
+			},
+		},
+	}
+}
+
+// newPod creates a running, ready pod of the web app on node-1, owned by
+// kind/name unless kind is empty.
+func newPod(name, ownerKind, ownerName string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID("default/" + name),
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("500m"),
+						corev1.ResourceMemory: resource.MustParse("1Gi"),
+					},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{newControllerRef(ownerKind, ownerName)}
+	}
+	return pod
+}
+
+func newControllerRef(kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{APIVersion: "apps/v1", Kind: kind, Name: name, UID: types.UID(kind + "/" + name), Controller: &controller}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
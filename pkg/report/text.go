@@ -0,0 +1,267 @@
+package report
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+func (r *DrainReport) text() (string, error) {
+	return utils.TabbedString(func(out io.Writer) error {
+		printer := utils.New(out)
+		printer.Write(0, "DrainNodes:\t%s\n", strings.Join(r.DrainNodes, ", "))
+		printer.Write(0, "Verdict:\t%s\n", r.Verdict)
+		if len(r.Reasons) != 0 {
+			printer.Write(0, "Reasons:\n")
+			printer.Write(1, "severity\trule\tobject\tmessage\n")
+			for _, reason := range r.Reasons {
+				printer.Write(1, "%s\t%s\t%s\t%s\n", reason.Severity, reason.Rule, reason.object(), reason.Message)
+			}
+		}
+		if len(r.ReplicaSetPods) == 0 {
+			printer.Write(0, "ReplicaSetPods:\t <none>\n")
+		} else {
+			printer.Write(0, "ReplicaSetPods:\n")
+			printer.Write(1, "owner\townerKind\tpodName\tnamespace\tnodeName\thasHostPath\tcpuReq\tcpuLimit\tmemReq\tmemLimit\n")
+			for _, pod := range r.ReplicaSetPods {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, pod.NodeName, fmt.Sprintf("%v", pod.HostPath == true), pod.CpuRequest, pod.CpuLimit, pod.MemRequest, pod.MemLimit)
+			}
+		}
+
+		if len(r.StatefulSetPods) == 0 {
+			printer.Write(0, "StatefulSetPods:\t <none>\n")
+		} else {
+			printer.Write(0, "StatefulSetPods:\n")
+			printer.Write(1, "owner\townerKind\tpodName\tnamespace\tnodeName\thasHostPath\tcpuReq\tcpuLimit\tmemReq\tmemLimit\n")
+
+			for _, pod := range r.StatefulSetPods {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, pod.NodeName, fmt.Sprintf("%v", pod.HostPath == true), pod.CpuRequest, pod.CpuLimit, pod.MemRequest, pod.MemLimit)
+			}
+		}
+
+		if len(r.DaemonSetPods) == 0 {
+			printer.Write(0, "DaemonSetPods:\t <none>\n")
+		} else {
+			printer.Write(0, "DaemonSetPods:\n")
+			printer.Write(1, "owner\townerKind\tpodName\tnamespace\tnodeName\thasHostPath\tcpuReq\tcpuLimit\tmemReq\tmemLimit\n")
+
+			for _, pod := range r.DaemonSetPods {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, pod.NodeName, fmt.Sprintf("%v", pod.HostPath == true), pod.CpuRequest, pod.CpuLimit, pod.MemRequest, pod.MemLimit)
+			}
+		}
+
+		if len(r.OtherPods) == 0 {
+			printer.Write(0, "OtherPods:\t <none>\n")
+		} else {
+			printer.Write(0, "OtherPods:\n")
+			printer.Write(1, "owner\townerKind\tpodName\tnamespace\tnodeName\thasHostPath\tcpuReq\tcpuLimit\tmemReq\tmemLimit\n")
+
+			for _, pod := range r.OtherPods {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, pod.NodeName, fmt.Sprintf("%v", pod.HostPath == true), pod.CpuRequest, pod.CpuLimit, pod.MemRequest, pod.MemLimit)
+			}
+		}
+
+		if len(r.IsolatedPods) == 0 {
+			printer.Write(0, "IsolatedPods:\t<none>\n")
+		} else {
+			printer.Write(0, "IsolatedPods\n  podName\tnamespace\tnodeName\thasHostPath\n")
+			for _, pod := range r.IsolatedPods {
+				printer.Write(1, "%s\t%s\t%s\t%s\n", pod.PodName, pod.Namespace, pod.NodeName, fmt.Sprintf("%v", pod.HostPath == true))
+			}
+		}
+
+		if len(r.Nodes) == 0 {
+			printer.Write(0, "Node:\tnone\n")
+		} else {
+			printer.Write(0, "Node:\n")
+			printer.Write(1, "nodeName\tdrain\tmaxPods\tcurrentPods\teips\tgpu\tschedule\tcpuAllocatable\tmemAllocatable\tcpuAllocated\tmemAllocated\totherAllocated\n")
+			for _, node := range r.Nodes {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					node.NodeName, fmt.Sprintf("%v", node.Drain == true), node.MaxPods, node.CurrentPods, node.Eips, fmt.Sprintf("%v", node.GpuNode == true), fmt.Sprintf("%v", node.Schedule == true), node.CpuAllocatable, node.MemAllocatable, node.CpuAllocated, node.MemAllocated, otherUsage(node.Allocatable, node.Allocated))
+			}
+		}
+
+		if len(r.PodPlacements) == 0 {
+			printer.Write(0, "Reschedule:\t<none>\n")
+		} else {
+			printer.Write(0, "Reschedule:\n")
+			printer.Write(1, "podName\tnamespace\tcpuReq\tmemReq\totherReq\teligibleNodes\tfitNodes\ttargetNode\n")
+			for _, pp := range r.PodPlacements {
+				fitNodes := fmt.Sprintf("%d", pp.FitNodes)
+				if pp.FitNodes == 0 {
+					fitNodes = "nowhere"
+				}
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					pp.PodName, pp.Namespace, pp.CpuRequest, pp.MemRequest, orNone(check.FormatResources(check.OtherResources(pp.Requests))), pp.EligibleNodes, fitNodes, pp.TargetNode)
+			}
+		}
+
+		var unschedulable []check.PodPlacement
+		for _, pp := range r.PodPlacements {
+			if pp.TargetNode == "" {
+				unschedulable = append(unschedulable, pp)
+			}
+		}
+		if len(unschedulable) == 0 {
+			printer.Write(0, "UnschedulablePods:\t<none>\n")
+		} else {
+			printer.Write(0, "UnschedulablePods:\n")
+			printer.Write(1, "podName\tnamespace\teligibleNodes\treasons\n")
+			for _, pp := range unschedulable {
+				printer.Write(1, "%s\t%s\t%s\t%s\n", pp.PodName, pp.Namespace, pp.EligibleNodes, strings.Join(pp.Reasons, ", "))
+			}
+		}
+
+		if len(r.DestinationNodes) == 0 {
+			printer.Write(0, "DestinationNodes:\tnone\n")
+		} else {
+			printer.Write(0, "DestinationNodes:\n")
+			printer.Write(1, "nodeName\tnewPods\tcpuAllocatable\tmemAllocatable\tcpuRequested\tmemRequested\tcpuUsage\tmemUsage\totherRequested\tpods\tpodHeadroom\n")
+			for _, nu := range r.DestinationNodes {
+				headroom := "unknown"
+				if nu.PodHeadroom != nil {
+					headroom = fmt.Sprintf("%d", *nu.PodHeadroom)
+				}
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s%%\t%s%%\t%s\t%s\t%s\n",
+					nu.NodeName, nu.NewPods, nu.CpuAllocatable, nu.MemAllocatable, nu.CpuRequested, nu.MemRequested, nu.CpuPercent, nu.MemPercent, otherUsage(nu.Allocatable, nu.Requested), fmt.Sprintf("%d/%d", nu.Pods, nu.MaxPods), headroom)
+			}
+		}
+
+		if len(r.Workloads) == 0 {
+			printer.Write(0, "Workloads:\tnone\n")
+		} else {
+			printer.Write(0, "Workloads:\n")
+			printer.Write(1, "kind\tname\tnamespace\tdesired\tready\tonDrainNodes\tavailableAfter\tdowntime\n")
+			for _, wd := range r.Workloads {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					wd.Kind, wd.Name, wd.Namespace, fmt.Sprintf("%d", wd.DesiredReplicas), fmt.Sprintf("%d", wd.ReadyReplicas),
+					fmt.Sprintf("%d", wd.DrainReplicas), fmt.Sprintf("%d", wd.AvailableAfter), fmt.Sprintf("%v", wd.Downtime))
+			}
+		}
+
+		if len(r.PodDisruptionBudgets) == 0 {
+			printer.Write(0, "PodDisruptionBudget:\tnone\n")
+		} else {
+			printer.Write(0, "PodDisruptionBudget:\n")
+			for _, pdb := range r.PodDisruptionBudgets {
+				printer.Write(0, "pdbName:\t%s\n", pdb.PdbName)
+				printer.Write(0, "pdbNamespace:\t%s\n", pdb.PdbNamespace)
+				printer.Write(0, "pdbMinAvailable:\t%s\n", pdb.PdbMinAvailable)
+				printer.Write(0, "pdbMaxUnavailable:\t%s\n", pdb.PdbMaxUnavailable)
+				printer.Write(0, "pdbAllowed:\t%s\n", pdb.PdbAllowed)
+				printer.Write(0, "evictedPods:\t%s\n", pdb.EvictedPods)
+				printer.Write(0, "verdict:\t%s\n", pdb.Verdict)
+				if len(pdb.PodDetails) != 0 {
+					printer.Write(1, "owner\townerKind\tpodName\tnamespace\tnodeName\n")
+					for _, pod := range pdb.PodDetails {
+						printer.Write(1, "%s\t%s\t%s\t%s\t%s\n", pod.OwnerRef, pod.OwnerRefKind, pod.PodName, pod.Namespace, pod.NodeName)
+					}
+				}
+			}
+
+		}
+
+		if len(r.Services) == 0 {
+			printer.Write(0, "Services:\tnone\n")
+		} else {
+			printer.Write(0, "Services:\n")
+			printer.Write(1, "serviceName\tnamespace\ttype\treadyEndpoints\tdrainEndpoints\tremainingEndpoints\toutage\tlocalTrafficDropped\n")
+			for _, svc := range r.Services {
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					svc.ServiceName, svc.Namespace, svc.Type, svc.ReadyEndpoints, svc.DrainEndpoints, svc.RemainingEndpoints, fmt.Sprintf("%v", svc.Outage == true), fmt.Sprintf("%v", svc.LocalTrafficDropped == true))
+			}
+		}
+
+		if len(r.LocalStorage) == 0 {
+			printer.Write(0, "LocalStorage:\tnone\n")
+		} else {
+			printer.Write(0, "LocalStorage:\n")
+			printer.Write(1, "podName\tnamespace\tnodeName\tvolume\ttype\tmedium\tsizeLimit\tpath\tsubPaths\tdataLoss\n")
+			for _, sd := range r.LocalStorage {
+				for _, vd := range sd.Volumes {
+					printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+						sd.PodName, sd.Namespace, sd.NodeName, vd.VolumeName, vd.VolumeType, vd.Medium, vd.SizeLimit, vd.Path, strings.Join(vd.SubPaths, ","), vd.DataLoss)
+				}
+			}
+		}
+
+		if len(r.Addresses) == 0 {
+			printer.Write(0, "Addresses:\tnone\n")
+		} else {
+			printer.Write(0, "Addresses:\n")
+			printer.Write(1, "podName\tnamespace\tnodeName\tmatcher\ttype\taddresses\tlost\tcandidateNodes\n")
+			for _, ad := range r.Addresses {
+				candidates := strings.Join(ad.CandidateNodes, ",")
+				if ad.Lost {
+					candidates = "-"
+				} else if ad.Pinned {
+					candidates = "<none>"
+				}
+				printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					ad.PodName, ad.Namespace, ad.NodeName, ad.Matcher, ad.Type, orNone(strings.Join(ad.Addresses, ",")), fmt.Sprintf("%v", ad.Lost), candidates)
+			}
+		}
+
+		if len(r.PersistentVolumes) == 0 {
+			printer.Write(0, "PersistentVolumes:\tnone\n")
+		} else {
+			printer.Write(0, "PersistentVolumes:\n")
+			printer.Write(1, "podName\tnamespace\tclaimName\tvolumeName\tlocal\ttopology\tcandidateNodes\tstuck\n")
+			for _, pvd := range r.PersistentVolumes {
+				for _, cd := range pvd.Claims {
+					printer.Write(1, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+						pvd.PodName, pvd.Namespace, cd.ClaimName, cd.VolumeName, fmt.Sprintf("%v", cd.Local == true), cd.Topology, len(pvd.CandidateNodes), fmt.Sprintf("%v", pvd.Stuck == true))
+				}
+			}
+		}
+
+		if len(r.InvalidPodDisruptionBudgets) != 0 {
+			printer.Write(0, "InvalidPodDisruptionBudgets:\n")
+			printer.Write(1, "pdbName\tpdbNamespace\terror\n")
+			for _, pdb := range r.InvalidPodDisruptionBudgets {
+				printer.Write(1, "%s\t%s\t%s\n", pdb.PdbName, pdb.PdbNamespace, pdb.SelectorError)
+			}
+		}
+
+		return nil
+	})
+}
+
+// otherUsage formats the resources besides cpu and memory as
+// name=requested/allocatable.
+func otherUsage(allocatable, requested corev1.ResourceList) string {
+	names := make(map[string]corev1.ResourceName)
+	for name := range check.OtherResources(allocatable) {
+		names[string(name)] = name
+	}
+	for name := range check.OtherResources(requested) {
+		names[string(name)] = name
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	pairs := make([]string, 0, len(sorted))
+	for _, name := range sorted {
+		used, total := requested[names[name]], allocatable[names[name]]
+		pairs = append(pairs, fmt.Sprintf("%s=%s/%s", name, used.String(), total.String()))
+	}
+	return orNone(strings.Join(pairs, ","))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
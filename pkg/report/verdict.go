@@ -0,0 +1,158 @@
+package report
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"strings"
+)
+
+const (
+	VERDICT_SAFE     = "SAFE"
+	VERDICT_WARNINGS = "WARNINGS"
+	VERDICT_BLOCKED  = "BLOCKED"
+)
+
+// The kinds of objects the reasons are about, reasons about workloads carry
+// the kind of the workload.
+const (
+	KIND_POD     = "Pod"
+	KIND_PDB     = "PodDisruptionBudget"
+	KIND_SERVICE = "Service"
+	KIND_NODE    = "Node"
+)
+
+// Reason is one finding contributing to the verdict, with the policy rule
+// that decided its severity.
+type Reason struct {
+	Rule      string `json:"rule"`
+	Check     string `json:"check"`
+	Severity  string `json:"severity"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Message   string `json:"message"`
+}
+
+func (r Reason) object() string {
+	if r.Namespace == "" {
+		return r.Kind + "/" + r.Name
+	}
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// Evaluate derives the overall verdict from the findings of the checkers,
+// the policy decides the severity of each one. A single blocking reason
+// blocks the drain, any warning turns a safe drain into one with warnings.
+func (r *DrainReport) Evaluate(p *policy.Policy) {
+	r.Reasons = []Reason{}
+	finding := func(checkName, kind, ns, name string, objLabels map[string]string, format string, a ...interface{}) {
+		rule := p.Match(checkName, ns, objLabels)
+		if rule == nil || rule.Severity == policy.SEVERITY_IGNORE {
+			return
+		}
+		r.Reasons = append(r.Reasons, Reason{
+			Rule:      rule.Name,
+			Check:     checkName,
+			Severity:  rule.Severity,
+			Kind:      kind,
+			Namespace: ns,
+			Name:      name,
+			Message:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	pods := make(map[string]check.PodDetail)
+	for _, group := range [][]check.PodDetail{r.ReplicaSetPods, r.StatefulSetPods, r.DaemonSetPods, r.OtherPods, r.IsolatedPods} {
+		for _, pod := range group {
+			pods[pod.Namespace+"/"+pod.PodName] = pod
+		}
+	}
+	podFinding := func(checkName, ns, name string, format string, a ...interface{}) {
+		finding(checkName, KIND_POD, ns, name, pods[ns+"/"+name].Labels, format, a...)
+	}
+
+	for _, pod := range r.IsolatedPods {
+		podFinding(policy.CHECK_ISOLATED_POD, pod.Namespace, pod.PodName, "pod is not managed by a controller and won't be recreated")
+	}
+	for _, pp := range r.PodPlacements {
+		if pp.TargetNode == "" {
+			podFinding(policy.CHECK_UNSCHEDULABLE_POD, pp.Namespace, pp.PodName, "pod fits on no other node: %s", strings.Join(pp.Reasons, ", "))
+		}
+	}
+	for _, pvd := range r.PersistentVolumes {
+		if pvd.Stuck {
+			podFinding(policy.CHECK_STUCK_VOLUME, pvd.Namespace, pvd.PodName, "no node is left to attach the volumes of the pod")
+		}
+	}
+	for _, sd := range r.LocalStorage {
+		for _, vd := range sd.Volumes {
+			podFinding(policy.CHECK_LOCAL_DATA_LOSS, sd.Namespace, sd.PodName, "volume %s: %s", vd.VolumeName, vd.DataLoss)
+		}
+	}
+	for _, ad := range r.Addresses {
+		if ad.Lost {
+			podFinding(policy.CHECK_ADDRESS_LOST, ad.Namespace, ad.PodName, "%s %s is released on eviction", ad.Type, strings.Join(ad.Addresses, ","))
+		} else if ad.Pinned {
+			podFinding(policy.CHECK_ADDRESS_PINNED, ad.Namespace, ad.PodName, "no node is left the %s %s can move to", ad.Type, strings.Join(ad.Addresses, ","))
+		}
+	}
+	for _, group := range [][]check.PodDetail{r.ReplicaSetPods, r.StatefulSetPods, r.OtherPods, r.IsolatedPods} {
+		for _, pod := range group {
+			// mirror pods stay on the node
+			if pod.HostPath && pod.OwnerRefKind != check.NODE_OWNER {
+				podFinding(policy.CHECK_HOST_PATH, pod.Namespace, pod.PodName, "pod mounts a hostPath volume")
+			}
+		}
+	}
+
+	for _, wd := range r.Workloads {
+		if wd.SingleReplica {
+			finding(policy.CHECK_SINGLE_REPLICA, wd.Kind, wd.Namespace, wd.Name, wd.Labels,
+				"single replica workload is down until its pod runs elsewhere")
+		} else if wd.AvailableAfter == 0 {
+			finding(policy.CHECK_ALL_REPLICAS_DRAINED, wd.Kind, wd.Namespace, wd.Name, wd.Labels,
+				"all %d ready replicas run on the drain nodes", wd.ReadyReplicas)
+		}
+	}
+
+	for _, pdb := range r.PodDisruptionBudgets {
+		switch pdb.Verdict {
+		case check.PDB_BLOCKS_DRAIN:
+			finding(policy.CHECK_PDB_BLOCKS_DRAIN, KIND_PDB, pdb.PdbNamespace, pdb.PdbName, pdb.Labels,
+				"budget allows no disruption, %d pods have to be evicted", pdb.EvictedPods)
+		case check.PDB_SLOWS_DRAIN:
+			finding(policy.CHECK_PDB_SLOWS_DRAIN, KIND_PDB, pdb.PdbNamespace, pdb.PdbName, pdb.Labels,
+				"budget allows %d disruptions for %d evicted pods, the drain has to wait", pdb.PdbAllowed, pdb.EvictedPods)
+		}
+	}
+	for _, pdb := range r.InvalidPodDisruptionBudgets {
+		finding(policy.CHECK_INVALID_PDB, KIND_PDB, pdb.PdbNamespace, pdb.PdbName, pdb.Labels, "budget is ignored: %s", pdb.SelectorError)
+	}
+
+	for _, svc := range r.Services {
+		if svc.Outage {
+			finding(policy.CHECK_SERVICE_OUTAGE, KIND_SERVICE, svc.Namespace, svc.ServiceName, svc.Labels,
+				"service loses all of its %d ready endpoints", svc.ReadyEndpoints)
+		}
+		if svc.LocalTrafficDropped {
+			finding(policy.CHECK_LOCAL_TRAFFIC_DROP, KIND_SERVICE, svc.Namespace, svc.ServiceName, svc.Labels,
+				"load balancer traffic sent to the drain nodes is dropped")
+		}
+	}
+
+	for _, node := range r.Nodes {
+		if node.Drain && node.GpuNode {
+			finding(policy.CHECK_GPU_NODE, KIND_NODE, "", node.NodeName, node.Labels, "drain node provides GPUs")
+		}
+	}
+
+	r.Verdict = VERDICT_SAFE
+	for _, reason := range r.Reasons {
+		if reason.Severity == policy.SEVERITY_BLOCK {
+			r.Verdict = VERDICT_BLOCKED
+			return
+		}
+		r.Verdict = VERDICT_WARNINGS
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// METRICS_CONTENT_TYPE is the Prometheus text exposition format.
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	METRIC_NODE_DRAINABLE           = "detect_drain_node_drainable"
+	METRIC_BLOCKING_PDBS            = "detect_drain_blocking_pdbs"
+	METRIC_UNSCHEDULABLE_PODS       = "detect_drain_unschedulable_pods"
+	METRIC_SINGLE_REPLICA_WORKLOADS = "detect_drain_single_replica_workloads"
+	METRIC_PDB_DISRUPTIONS_ALLOWED  = "detect_drain_pdb_disruptions_allowed"
+)
+
+// gauge is a metric family with one sample per set of label values.
+type gauge struct {
+	name       string
+	help       string
+	labelNames []string
+	samples    []gaugeSample
+}
+
+type gaugeSample struct {
+	labelValues []string
+	value       float64
+}
+
+func newGauge(name, help string, labelNames ...string) *gauge {
+	return &gauge{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+	}
+}
+
+func (g *gauge) set(value float64, labelValues ...string) {
+	g.samples = append(g.samples, gaugeSample{labelValues: labelValues, value: value})
+}
+
+func (g *gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	for _, sample := range g.samples {
+		labels := make([]string, 0, len(g.labelNames))
+		for i, name := range g.labelNames {
+			labels = append(labels, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(sample.labelValues[i])))
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", g.name, strings.Join(labels, ","), strconv.FormatFloat(sample.value, 'g', -1, 64))
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// metrics exports the last assessments of draining every node on its own and
+// the disruptions every budget allowed in the assessed state.
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	assessments, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+
+	drainable := newGauge(METRIC_NODE_DRAINABLE, "Whether draining the node on its own is not blocked by the policy.", "node")
+	blockingPdbs := newGauge(METRIC_BLOCKING_PDBS, "Number of pod disruption budgets blocking the drain of the node.", "node")
+	unschedulablePods := newGauge(METRIC_UNSCHEDULABLE_PODS, "Number of pods evicted by the drain of the node that fit on no other node.", "node")
+	singleReplicaWorkloads := newGauge(METRIC_SINGLE_REPLICA_WORKLOADS, "Number of single replica workloads with a pod on the node.", "node")
+	for _, node := range assessments.snapshot.Nodes {
+		drainReport := assessments.reports[node.Name]
+
+		var blocking, unschedulable, singleReplica int
+		for _, pdb := range drainReport.PodDisruptionBudgets {
+			if pdb.Verdict == check.PDB_BLOCKS_DRAIN {
+				blocking++
+			}
+		}
+		for _, placement := range drainReport.PodPlacements {
+			if placement.TargetNode == "" {
+				unschedulable++
+			}
+		}
+		for _, workload := range drainReport.Workloads {
+			if workload.SingleReplica {
+				singleReplica++
+			}
+		}
+
+		if drainReport.Verdict == report.VERDICT_BLOCKED {
+			drainable.set(0, node.Name)
+		} else {
+			drainable.set(1, node.Name)
+		}
+		blockingPdbs.set(float64(blocking), node.Name)
+		unschedulablePods.set(float64(unschedulable), node.Name)
+		singleReplicaWorkloads.set(float64(singleReplica), node.Name)
+	}
+
+	disruptionsAllowed := newGauge(METRIC_PDB_DISRUPTIONS_ALLOWED, "Number of pod disruptions the budget currently allows.", "namespace", "pdb")
+	for _, pdb := range assessments.snapshot.PodDisruptionBudgets {
+		disruptionsAllowed.set(float64(pdb.Status.PodDisruptionsAllowed), pdb.Namespace, pdb.Name)
+	}
+
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	for _, g := range []*gauge{drainable, blockingPdbs, unschedulablePods, singleReplicaWorkloads, disruptionsAllowed} {
+		g.write(w)
+	}
+}
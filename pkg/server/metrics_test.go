@@ -0,0 +1,135 @@
+package server
+
+import (
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"net/http"
+	"testing"
+)
+
+const GPU_RESOURCE corev1.ResourceName = "nvidia.com/gpu"
+
+func TestMetrics(t *testing.T) {
+	minAvailable := intstr.FromInt(1)
+	gpuNode := newNode("node-2")
+	gpuNode.Status.Allocatable[GPU_RESOURCE] = resource.MustParse("1")
+	gpuPod := newOwnedPod("batch-1", "node-2", "batch", "100m")
+	gpuPod.Spec.Containers[0].Resources.Requests[GPU_RESOURCE] = resource.MustParse("1")
+	_, drainServer, s := newTestServer(t,
+		newNode("node-1"),
+		gpuNode,
+		// the single replica of web is on node-1 and its budget allows no
+		// disruption
+		newReplicaSet("web", 1),
+		newOwnedPod("web-1", "node-1", "web", "100m"),
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				MinAvailable: &minAvailable,
+				Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+		},
+		// a pod of batch on node-2 requests the gpu node-1 doesn't have
+		newReplicaSet("batch", 2),
+		gpuPod,
+		newOwnedPod("batch-2", "node-1", "batch", "100m"),
+	)
+	defer s.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	start(t, drainServer, stopCh)
+
+	resp, err := http.Get(s.URL + PATH_METRICS)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d from %s, got %d", http.StatusOK, PATH_METRICS, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != METRICS_CONTENT_TYPE {
+		t.Errorf("expected content type %q, got %q", METRICS_CONTENT_TYPE, contentType)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `# HELP detect_drain_node_drainable Whether draining the node on its own is not blocked by the policy.
+# TYPE detect_drain_node_drainable gauge
+detect_drain_node_drainable{node="node-1"} 0
+detect_drain_node_drainable{node="node-2"} 0
+# HELP detect_drain_blocking_pdbs Number of pod disruption budgets blocking the drain of the node.
+# TYPE detect_drain_blocking_pdbs gauge
+detect_drain_blocking_pdbs{node="node-1"} 1
+detect_drain_blocking_pdbs{node="node-2"} 0
+# HELP detect_drain_unschedulable_pods Number of pods evicted by the drain of the node that fit on no other node.
+# TYPE detect_drain_unschedulable_pods gauge
+detect_drain_unschedulable_pods{node="node-1"} 0
+detect_drain_unschedulable_pods{node="node-2"} 1
+# HELP detect_drain_single_replica_workloads Number of single replica workloads with a pod on the node.
+# TYPE detect_drain_single_replica_workloads gauge
+detect_drain_single_replica_workloads{node="node-1"} 1
+detect_drain_single_replica_workloads{node="node-2"} 0
+# HELP detect_drain_pdb_disruptions_allowed Number of pod disruptions the budget currently allows.
+# TYPE detect_drain_pdb_disruptions_allowed gauge
+detect_drain_pdb_disruptions_allowed{namespace="default",pdb="web"} 0
+`
+	if string(body) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, body)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if escaped := escapeLabelValue("a\\b\"c\nd"); escaped != `a\\b\"c\nd` {
+		t.Errorf("unexpected escaped label value %s", escaped)
+	}
+}
+
+func newReplicaSet(name string, replicas int32) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       appsv1.ReplicaSetSpec{Replicas: &replicas},
+		Status:     appsv1.ReplicaSetStatus{ReadyReplicas: replicas},
+	}
+}
+
+// newOwnedPod creates a running, ready pod of the replica set labeled with
+// the replica set name.
+func newOwnedPod(name, nodeName, replicaSet, cpu string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": replicaSet},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       replicaSet,
+				UID:        types.UID(replicaSet),
+				Controller: &controller,
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name: "main",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NODE_LIST_API_VERSION = report.REPORT_API_VERSION
+	NODE_LIST_KIND        = "NodeAssessmentList"
+)
+
+const (
+	PATH_NODES   = "/v1/nodes"
+	PATH_HEALTHZ = "/healthz"
+	PATH_READYZ  = "/readyz"
+	PATH_METRICS = "/metrics"
+)
+
+// NodeSummary is the verdict of draining a node on its own.
+type NodeSummary struct {
+	Name     string `json:"name"`
+	Verdict  string `json:"verdict"`
+	Blocking int    `json:"blocking"`
+	Warnings int    `json:"warnings"`
+}
+
+type NodeList struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Items      []NodeSummary `json:"items"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server answers drain assessments over HTTP and exports them as Prometheus
+// metrics from the watched cluster state, with the checkers and the policy of
+// the command line. The nodes are assessed in the background whenever the
+// state changed, requests are answered from the last assessments.
+type Server struct {
+	cache    *snapshot.Cache
+	matchers []check.AddressMatcher
+	policy   *policy.Policy
+	interval time.Duration
+	mux      *http.ServeMux
+
+	lock        sync.RWMutex
+	assessments *nodeAssessments
+}
+
+// nodeAssessments are the reports of draining every node on its own, assessed
+// from one snapshot.
+type nodeAssessments struct {
+	generation uint64
+	snapshot   *snapshot.Snapshot
+	reports    map[string]*report.DrainReport
+}
+
+// NewServer creates a server reassessing the nodes at most every interval.
+func NewServer(cache *snapshot.Cache, matchers []check.AddressMatcher, p *policy.Policy, interval time.Duration) *Server {
+	s := &Server{
+		cache:    cache,
+		matchers: matchers,
+		policy:   p,
+		interval: interval,
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc(PATH_HEALTHZ, s.healthz)
+	s.mux.HandleFunc(PATH_READYZ, s.readyz)
+	s.mux.HandleFunc(PATH_METRICS, s.metrics)
+	s.mux.HandleFunc(PATH_NODES, s.nodes)
+	s.mux.HandleFunc(PATH_NODES+"/", s.assessment)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run waits for the cluster state and reassesses the nodes every interval in
+// which it changed, until stopCh is closed.
+func (s *Server) Run(stopCh <-chan struct{}) {
+	if !s.cache.WaitForCacheSync(stopCh) {
+		return
+	}
+	klog.Infof("Cluster state synced")
+	wait.Until(s.assess, s.interval, stopCh)
+}
+
+// assess assesses draining every node on its own unless the state didn't
+// change since the last assessments.
+func (s *Server) assess() {
+	// read before the snapshot, a change while it is taken is assessed again
+	generation := s.cache.Generation()
+	if last := s.lastAssessments(); last != nil && last.generation == generation {
+		return
+	}
+
+	snap, err := s.cache.Snapshot()
+	if err != nil {
+		klog.Errorf("Failed to snapshot the cluster state: %v", err)
+		return
+	}
+	assessments := &nodeAssessments{
+		generation: generation,
+		snapshot:   snap,
+		reports:    make(map[string]*report.DrainReport),
+	}
+	// the node independent parts are derived once for all nodes
+	index := check.NewIndex(snap, s.matchers)
+	for _, node := range snap.Nodes {
+		drainReport, err := report.Assess([]string{node.Name}, index, s.policy)
+		if err != nil {
+			klog.Errorf("Failed to assess node %s: %v", node.Name, err)
+			return
+		}
+		assessments.reports[node.Name] = drainReport
+	}
+
+	s.lock.Lock()
+	s.assessments = assessments
+	s.lock.Unlock()
+}
+
+func (s *Server) lastAssessments() *nodeAssessments {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.assessments
+}
+
+// healthz answers as long as the server runs.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz answers once the nodes are assessed.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.lastAssessments() == nil {
+		http.Error(w, "nodes not assessed yet", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// nodes summarizes the assessment of draining every node on its own.
+func (s *Server) nodes(w http.ResponseWriter, r *http.Request) {
+	assessments, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+
+	list := NodeList{
+		APIVersion: NODE_LIST_API_VERSION,
+		Kind:       NODE_LIST_KIND,
+		Items:      []NodeSummary{},
+	}
+	for _, node := range assessments.snapshot.Nodes {
+		list.Items = append(list.Items, summarize(node.Name, assessments.reports[node.Name]))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// assessment answers GET /v1/nodes/{name}/assessment with the report of
+// draining the node.
+func (s *Server) assessment(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, PATH_NODES+"/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "assessment" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	}
+	name := parts[0]
+
+	assessments, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+	drainReport, ok := assessments.reports[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("node %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, drainReport)
+}
+
+// get checks the request can be answered and returns the last assessments,
+// false if the error is written already.
+func (s *Server) get(w http.ResponseWriter, r *http.Request) (*nodeAssessments, bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return nil, false
+	}
+	assessments := s.lastAssessments()
+	if assessments == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("nodes not assessed yet"))
+		return nil, false
+	}
+	return assessments, true
+}
+
+func summarize(name string, drainReport *report.DrainReport) NodeSummary {
+	summary := NodeSummary{
+		Name:    name,
+		Verdict: drainReport.Verdict,
+	}
+	for _, reason := range drainReport.Reasons {
+		switch reason.Severity {
+		case policy.SEVERITY_BLOCK:
+			summary.Blocking++
+		case policy.SEVERITY_WARN:
+			summary.Warnings++
+		}
+	}
+	return summary
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		klog.Errorf("Failed to marshal response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	if code >= http.StatusInternalServerError {
+		klog.Errorf("Failed to answer request: %v", err)
+	}
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
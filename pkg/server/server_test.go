@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/coderwangke/detect-drain/pkg/check"
+	"github.com/coderwangke/detect-drain/pkg/policy"
+	"github.com/coderwangke/detect-drain/pkg/report"
+	"github.com/coderwangke/detect-drain/pkg/snapshot"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	_, drainServer, s := newTestServer(t,
+		newNode("node-1"),
+		newNode("node-2"),
+		// a bare pod is not recreated and blocks the drain of node-1
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-1", Containers: []corev1.Container{{Name: "main"}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+	defer s.Close()
+
+	if code := get(t, s.URL+PATH_READYZ, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d before the assessment, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	start(t, drainServer, stopCh)
+
+	if code := get(t, s.URL+PATH_HEALTHZ, nil); code != http.StatusOK {
+		t.Errorf("expected %d from %s, got %d", http.StatusOK, PATH_HEALTHZ, code)
+	}
+	if code := get(t, s.URL+PATH_READYZ, nil); code != http.StatusOK {
+		t.Errorf("expected %d after the assessment, got %d", http.StatusOK, code)
+	}
+
+	list := NodeList{}
+	if code := get(t, s.URL+"/v1/nodes", &list); code != http.StatusOK {
+		t.Fatalf("expected %d from /v1/nodes, got %d", http.StatusOK, code)
+	}
+	expected := []NodeSummary{
+		{Name: "node-1", Verdict: report.VERDICT_BLOCKED, Blocking: 1},
+		{Name: "node-2", Verdict: report.VERDICT_SAFE},
+	}
+	if len(list.Items) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, list.Items)
+	}
+	for i := range expected {
+		if list.Items[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], list.Items[i])
+		}
+	}
+
+	drainReport := report.DrainReport{}
+	if code := get(t, s.URL+"/v1/nodes/node-1/assessment", &drainReport); code != http.StatusOK {
+		t.Fatalf("expected %d from the assessment, got %d", http.StatusOK, code)
+	}
+	if drainReport.Verdict != report.VERDICT_BLOCKED || len(drainReport.IsolatedPods) != 1 {
+		t.Errorf("expected node-1 to be blocked by the isolated pod, got %+v", drainReport)
+	}
+
+	for _, path := range []string{"/v1/nodes/node-3/assessment", "/v1/nodes/node-1", "/v1/nodes/node-1/pods"} {
+		if code := get(t, s.URL+path, nil); code != http.StatusNotFound {
+			t.Errorf("expected %d from %s, got %d", http.StatusNotFound, path, code)
+		}
+	}
+
+	resp, err := http.Post(s.URL+"/v1/nodes", "application/json", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d from POST, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestServerReassessesOnChange(t *testing.T) {
+	clientSet, drainServer, s := newTestServer(t, newNode("node-1"))
+	defer s.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	start(t, drainServer, stopCh)
+
+	assessed := drainServer.lastAssessments()
+	drainServer.assess()
+	if drainServer.lastAssessments() != assessed {
+		t.Errorf("expected the nodes not to be reassessed without a change")
+	}
+
+	generation := drainServer.cache.Generation()
+	if _, err := clientSet.CoreV1().Nodes().Create(newNode("node-2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return drainServer.cache.Generation() != generation, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the new node to change the cluster state")
+	}
+	drainServer.assess()
+
+	list := NodeList{}
+	if code := get(t, s.URL+PATH_NODES, &list); code != http.StatusOK {
+		t.Fatalf("expected %d from %s, got %d", http.StatusOK, PATH_NODES, code)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("expected both nodes to be assessed, got %v", list.Items)
+	}
+}
+
+// newTestServer serves the state of a fake cluster holding the objects with
+// the default checkers and policy. Nothing is watched before start.
+func newTestServer(t *testing.T, objs ...runtime.Object) (*fake.Clientset, *Server, *httptest.Server) {
+	t.Helper()
+	clientSet := fake.NewSimpleClientset(objs...)
+	client := &utils.KubeCient{
+		ClientSet:     clientSet,
+		DynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		RESTMapper:    meta.NewDefaultRESTMapper(nil),
+	}
+	matchers, err := check.LoadAddressMatchers("")
+	if err != nil {
+		t.Fatalf("failed to load the default address matchers: %v", err)
+	}
+	clusterCache := snapshot.NewCache(client, check.AddressResources(matchers)...)
+	drainServer := NewServer(clusterCache, matchers, policy.Default(), time.Second)
+	return clientSet, drainServer, httptest.NewServer(drainServer)
+}
+
+// start watches the fake cluster and assesses the nodes once synced.
+func start(t *testing.T, drainServer *Server, stopCh <-chan struct{}) {
+	t.Helper()
+	drainServer.cache.Start(stopCh)
+	if !drainServer.cache.WaitForCacheSync(stopCh) {
+		t.Fatalf("failed to sync the cache")
+	}
+	drainServer.assess()
+}
+
+// get requests the url and decodes the json response into v unless nil.
+func get(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode the response of %s: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func newNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("8Gi"),
+				corev1.ResourcePods:   resource.MustParse("110"),
+			},
+		},
+	}
+}
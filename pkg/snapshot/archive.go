@@ -0,0 +1,349 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+	"os"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	ARCHIVE_API_VERSION = "detectdrain.coderwangke.github.com/v1alpha1"
+	ARCHIVE_KIND        = "SnapshotArchive"
+	ARCHIVE_MANIFEST    = "manifest.yaml"
+)
+
+// Contents of the files of an archive.
+const (
+	CONTENT_OBJECTS  = "objects"
+	CONTENT_OWNERS   = "owners"
+	CONTENT_RESOURCE = "resource"
+)
+
+// lastAppliedAnnotation holds the whole object as applied, env values
+// included.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Manifest is the first file of an archive and lists the others in the
+// order they are replayed.
+type Manifest struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Created    metav1.Time    `json:"created"`
+	Files      []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	// Resource is the extra resource the objects of a resource file belong to.
+	Resource *ManifestResource `json:"resource,omitempty"`
+}
+
+type ManifestResource struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+}
+
+// IsArchive reports whether the dump path names an archive written by
+// WriteArchive.
+func IsArchive(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// WriteArchive writes the snapshot as gzipped tar, every kind to a yaml list
+// of its own. Secrets are left out, the values of environment variables and
+// the last applied configuration of the objects are stripped.
+func (s *Snapshot) WriteArchive(w io.Writer) error {
+	manifest := Manifest{
+		APIVersion: ARCHIVE_API_VERSION,
+		Kind:       ARCHIVE_KIND,
+		Created:    metav1.Now(),
+	}
+	files := make(map[string][]byte)
+
+	add := func(file ManifestFile, items []interface{}) error {
+		data, err := yaml.Marshal(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      items,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %v", file.Name, err)
+		}
+		manifest.Files = append(manifest.Files, file)
+		files[file.Name] = data
+		return nil
+	}
+
+	objects := []struct {
+		name  string
+		gv    schema.GroupVersion
+		kind  string
+		items []runtime.Object
+	}{
+		{name: "nodes.yaml", gv: corev1.SchemeGroupVersion, kind: "Node", items: toObjects(s.Nodes)},
+		{name: "pods.yaml", gv: corev1.SchemeGroupVersion, kind: "Pod", items: toObjects(s.Pods)},
+		{name: "replicasets.yaml", gv: appsv1.SchemeGroupVersion, kind: "ReplicaSet", items: toObjects(s.ReplicaSets)},
+		{name: "deployments.yaml", gv: appsv1.SchemeGroupVersion, kind: "Deployment", items: toObjects(s.Deployments)},
+		{name: "statefulsets.yaml", gv: appsv1.SchemeGroupVersion, kind: "StatefulSet", items: toObjects(s.StatefulSets)},
+		{name: "daemonsets.yaml", gv: appsv1.SchemeGroupVersion, kind: "DaemonSet", items: toObjects(s.DaemonSets)},
+		{name: "poddisruptionbudgets.yaml", gv: schema.GroupVersion{Group: "policy", Version: "v1beta1"}, kind: "PodDisruptionBudget", items: toObjects(s.PodDisruptionBudgets)},
+		{name: "services.yaml", gv: corev1.SchemeGroupVersion, kind: "Service", items: toObjects(s.Services)},
+		{name: "endpoints.yaml", gv: corev1.SchemeGroupVersion, kind: "Endpoints", items: toObjects(s.Endpoints)},
+		{name: "persistentvolumeclaims.yaml", gv: corev1.SchemeGroupVersion, kind: "PersistentVolumeClaim", items: toObjects(s.PersistentVolumeClaims)},
+		{name: "persistentvolumes.yaml", gv: corev1.SchemeGroupVersion, kind: "PersistentVolume", items: toObjects(s.PersistentVolumes)},
+		{name: "owners.yaml", items: toObjects(s.Owners)},
+	}
+	for _, o := range objects {
+		content := CONTENT_OBJECTS
+		if o.kind == "" {
+			content = CONTENT_OWNERS
+		}
+		items := make([]interface{}, 0, len(o.items))
+		for _, obj := range o.items {
+			u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+			if err != nil {
+				return err
+			}
+			// list items carry no type
+			if o.kind != "" {
+				u["apiVersion"] = o.gv.String()
+				u["kind"] = o.kind
+			}
+			items = append(items, sanitize(u))
+		}
+		if err := add(ManifestFile{Name: o.name, Content: content}, items); err != nil {
+			return err
+		}
+	}
+
+	gvrs := make([]schema.GroupVersionResource, 0, len(s.Resources))
+	for gvr := range s.Resources {
+		gvrs = append(gvrs, gvr)
+	}
+	sort.Slice(gvrs, func(i, j int) bool { return gvrs[i].String() < gvrs[j].String() })
+	for _, gvr := range gvrs {
+		if gvr.Group == "" && gvr.Resource == "secrets" {
+			continue
+		}
+		items := make([]interface{}, 0, len(s.Resources[gvr]))
+		for _, u := range s.Resources[gvr] {
+			items = append(items, sanitize(u.DeepCopy().Object))
+		}
+		file := ManifestFile{
+			Name:     resourceFileName(gvr),
+			Content:  CONTENT_RESOURCE,
+			Resource: &ManifestResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource},
+		}
+		if err := add(file, items); err != nil {
+			return err
+		}
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	write := func(name string, data []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: manifest.Created.Time,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(ARCHIVE_MANIFEST, data); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err := write(file.Name, files[file.Name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// FromArchive loads the snapshot written by WriteArchive. Extra resources
+// missing from the archive are left empty.
+func FromArchive(path string, extra ...schema.GroupVersionResource) (*Snapshot, error) {
+	fmt.Fprintln(os.Stderr, "starting loading cluster state from archive...")
+	f, err := os.Open(path)
+	if err != nil {
+		klog.Errorf("Failed to open archive %s: %v", path, err)
+		return nil, err
+	}
+	defer f.Close()
+
+	files, err := readArchive(f)
+	if err != nil {
+		klog.Errorf("Failed to read archive %s: %v", path, err)
+		return nil, err
+	}
+
+	data, ok := files[ARCHIVE_MANIFEST]
+	if !ok {
+		return nil, fmt.Errorf("archive %s has no %s", path, ARCHIVE_MANIFEST)
+	}
+	manifest := Manifest{}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s of archive %s: %v", ARCHIVE_MANIFEST, path, err)
+	}
+	if manifest.APIVersion != ARCHIVE_API_VERSION || manifest.Kind != ARCHIVE_KIND {
+		return nil, fmt.Errorf("unsupported archive %s %s, expected %s %s", manifest.APIVersion, manifest.Kind, ARCHIVE_API_VERSION, ARCHIVE_KIND)
+	}
+
+	s := &Snapshot{
+		Resources: make(map[schema.GroupVersionResource][]*unstructured.Unstructured),
+	}
+	for _, file := range manifest.Files {
+		data, ok := files[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("archive %s has no %s", path, file.Name)
+		}
+
+		var add func(u *unstructured.Unstructured) error
+		switch file.Content {
+		case CONTENT_OBJECTS:
+			add = s.load
+		case CONTENT_OWNERS:
+			add = s.loadOwner
+		case CONTENT_RESOURCE:
+			if file.Resource == nil {
+				return nil, fmt.Errorf("resource file %s of archive %s names no resource", file.Name, path)
+			}
+			gvr := schema.GroupVersionResource{Group: file.Resource.Group, Version: file.Resource.Version, Resource: file.Resource.Resource}
+			s.Resources[gvr] = []*unstructured.Unstructured{}
+			add = func(u *unstructured.Unstructured) error {
+				s.Resources[gvr] = append(s.Resources[gvr], u)
+				return nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown content %q of %s in archive %s", file.Content, file.Name, path)
+		}
+
+		if err := decodeAll(bytes.NewReader(data), add); err != nil {
+			klog.Errorf("Failed to load %s of archive %s: %v", file.Name, path, err)
+			return nil, err
+		}
+	}
+
+	for _, gvr := range extra {
+		if _, ok := s.Resources[gvr]; !ok {
+			s.Resources[gvr] = []*unstructured.Unstructured{}
+		}
+	}
+
+	s.index()
+	return s, nil
+}
+
+func readArchive(r io.Reader) (map[string][]byte, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = data
+	}
+}
+
+// resourceFileName names the file of an extra resource the way kubectl names
+// resources, e.g. resources/v1/ipamhandles.crd.projectcalico.org.yaml.
+func resourceFileName(gvr schema.GroupVersionResource) string {
+	name := gvr.Resource
+	if gvr.Group != "" {
+		name += "." + gvr.Group
+	}
+	return "resources/" + gvr.Version + "/" + name + ".yaml"
+}
+
+// toObjects turns a slice of pointers to api objects into a slice of
+// runtime.Object.
+func toObjects(list interface{}) []runtime.Object {
+	v := reflect.ValueOf(list)
+	objs := make([]runtime.Object, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		objs = append(objs, v.Index(i).Interface().(runtime.Object))
+	}
+	return objs
+}
+
+// sanitize strips the values of environment variables, the references to
+// config maps and secrets stay, from the containers of pods and pod
+// templates. The last applied configuration and the managed fields are
+// dropped.
+func sanitize(obj map[string]interface{}) map[string]interface{} {
+	unstructured.RemoveNestedField(obj, "metadata", "annotations", lastAppliedAnnotation)
+	unstructured.RemoveNestedField(obj, "metadata", "managedFields")
+
+	for _, spec := range [][]string{{"spec"}, {"spec", "template", "spec"}} {
+		for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+			containers, ok, _ := unstructured.NestedSlice(obj, append(spec, field)...)
+			if !ok {
+				continue
+			}
+			for _, container := range containers {
+				c, ok := container.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				env, _, _ := unstructured.NestedSlice(c, "env")
+				for _, e := range env {
+					if v, ok := e.(map[string]interface{}); ok {
+						delete(v, "value")
+					}
+				}
+				if env != nil {
+					c["env"] = env
+				}
+			}
+			unstructured.SetNestedSlice(obj, containers, append(spec, field)...)
+		}
+	}
+	return obj
+}
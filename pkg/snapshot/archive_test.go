@@ -0,0 +1,70 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	s, err := FromDump(filepath.Join("testdata", "dump"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pod := s.Pod("default", "web-1-a")
+	pod.Annotations = map[string]string{lastAppliedAnnotation: "{}"}
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "PASSWORD", Value: "secret"},
+		{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}}},
+	}
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	s.Resources[secrets] = []*unstructured.Unstructured{{Object: map[string]interface{}{
+		"apiVersion": "v1", "kind": "Secret", "metadata": map[string]interface{}{"name": "token"},
+	}}}
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.WriteArchive(f); err != nil {
+		t.Fatalf("failed to write the archive: %v", err)
+	}
+	f.Close()
+
+	replayed, err := FromDump(path)
+	if err != nil {
+		t.Fatalf("failed to replay the archive: %v", err)
+	}
+
+	if len(replayed.Nodes) != len(s.Nodes) || len(replayed.Pods) != len(s.Pods) ||
+		len(replayed.Deployments) != len(s.Deployments) || len(replayed.PodDisruptionBudgets) != len(s.PodDisruptionBudgets) ||
+		len(replayed.Owners) != len(s.Owners) {
+		t.Errorf("expected the objects of the snapshot to be replayed")
+	}
+	if _, ok := replayed.Resources[secrets]; ok {
+		t.Errorf("expected secrets to be left out")
+	}
+	jobs := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	if len(replayed.Resource(jobs)) != 1 {
+		t.Errorf("expected the job resource to be replayed")
+	}
+
+	pod = replayed.Pod("default", "web-1-a")
+	if _, ok := pod.Annotations[lastAppliedAnnotation]; ok {
+		t.Errorf("expected the last applied configuration to be stripped")
+	}
+	env := pod.Spec.Containers[0].Env
+	if len(env) != 2 || env[0].Value != "" || env[1].ValueFrom == nil || env[1].ValueFrom.SecretKeyRef == nil {
+		t.Errorf("expected env values to be stripped and references to be kept, got %+v", env)
+	}
+}
@@ -0,0 +1,293 @@
+package snapshot
+
+import (
+	"context"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ownerSyncTimeout bounds the wait for the first list of an owner kind, which
+// never completes e.g. if listing the kind is forbidden.
+var ownerSyncTimeout = 30 * time.Second
+
+// Cache keeps the cluster state the checkers read up to date by watching it,
+// snapshots are taken from memory.
+type Cache struct {
+	// generation counts the changes of the watched state, it is only
+	// accessed atomically and first for the alignment on 32-bit platforms.
+	generation uint64
+
+	client         *utils.KubeCient
+	factory        informers.SharedInformerFactory
+	dynamicFactory dynamicinformer.DynamicSharedInformerFactory
+	informers      []cache.SharedIndexInformer
+	// extra are the extra resources, those not served by the cluster have no
+	// informer and are left empty.
+	extra          []schema.GroupVersionResource
+	extraInformers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	stopCh         <-chan struct{}
+
+	lock sync.Mutex
+	// owners are the informers of the pod owner kinds referenced so far, they
+	// are watched from the first snapshot on which needs them.
+	owners map[schema.GroupKind]*ownerInformer
+}
+
+type ownerInformer struct {
+	apiVersion string
+	informer   cache.SharedIndexInformer
+	// syncFailed is set once the first list of the kind timed out, later
+	// snapshots don't wait for the informer again and use the owners as soon
+	// as it synced.
+	syncFailed bool
+}
+
+// NewCache sets up the informers of every kind the checkers read and of the
+// extra resources. Nothing is watched before Start.
+func NewCache(client *utils.KubeCient, extra ...schema.GroupVersionResource) *Cache {
+	factory := informers.NewSharedInformerFactory(client.ClientSet, 0)
+	c := &Cache{
+		client:         client,
+		factory:        factory,
+		dynamicFactory: dynamicinformer.NewDynamicSharedInformerFactory(client.DynamicClient, 0),
+		informers: []cache.SharedIndexInformer{
+			factory.Core().V1().Nodes().Informer(),
+			factory.Core().V1().Pods().Informer(),
+			factory.Apps().V1().ReplicaSets().Informer(),
+			factory.Apps().V1().Deployments().Informer(),
+			factory.Apps().V1().StatefulSets().Informer(),
+			factory.Apps().V1().DaemonSets().Informer(),
+			factory.Policy().V1beta1().PodDisruptionBudgets().Informer(),
+			factory.Core().V1().Services().Informer(),
+			factory.Core().V1().Endpoints().Informer(),
+			factory.Core().V1().PersistentVolumeClaims().Informer(),
+			factory.Core().V1().PersistentVolumes().Informer(),
+		},
+		extra:          extra,
+		extraInformers: make(map[schema.GroupVersionResource]cache.SharedIndexInformer),
+		owners:         make(map[schema.GroupKind]*ownerInformer),
+	}
+
+	for _, gvr := range extra {
+		// an informer of a resource the cluster doesn't serve never syncs
+		if _, err := client.RESTMapper.KindFor(gvr); err != nil {
+			klog.Errorf("Failed to find extra resource %s, it is left empty: %v", gvr, err)
+			continue
+		}
+		c.extraInformers[gvr] = c.dynamicFactory.ForResource(gvr).Informer()
+	}
+
+	for _, informer := range c.informers {
+		informer.AddEventHandler(c.changeHandler())
+	}
+	for _, informer := range c.extraInformers {
+		informer.AddEventHandler(c.changeHandler())
+	}
+
+	return c
+}
+
+// Generation changes whenever the watched state changes, snapshots taken at
+// the same generation are the same.
+func (c *Cache) Generation() uint64 {
+	return atomic.LoadUint64(&c.generation)
+}
+
+func (c *Cache) changeHandler() cache.ResourceEventHandler {
+	changed := func() {
+		atomic.AddUint64(&c.generation, 1)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { changed() },
+		UpdateFunc: func(oldObj, newObj interface{}) { changed() },
+		DeleteFunc: func(obj interface{}) { changed() },
+	}
+}
+
+// Start watches the cluster until stopCh is closed.
+func (c *Cache) Start(stopCh <-chan struct{}) {
+	c.stopCh = stopCh
+	c.factory.Start(stopCh)
+	c.dynamicFactory.Start(stopCh)
+}
+
+// WaitForCacheSync waits until the initial lists are in memory, false if
+// stopCh is closed before.
+func (c *Cache) WaitForCacheSync(stopCh <-chan struct{}) bool {
+	return cache.WaitForCacheSync(stopCh, c.hasSynced()...)
+}
+
+// HasSynced reports whether the initial lists are in memory.
+func (c *Cache) HasSynced() bool {
+	for _, synced := range c.hasSynced() {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) hasSynced() []cache.InformerSynced {
+	var synced []cache.InformerSynced
+	for _, informer := range c.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+	for _, informer := range c.extraInformers {
+		synced = append(synced, informer.HasSynced)
+	}
+	return synced
+}
+
+// Snapshot takes a snapshot of the cached state. The objects are shared with
+// the cache and must not be modified.
+func (c *Cache) Snapshot() (*Snapshot, error) {
+	s := &Snapshot{
+		Resources: make(map[schema.GroupVersionResource][]*unstructured.Unstructured),
+	}
+
+	for _, informer := range c.informers {
+		for _, obj := range sortedObjects(informer.GetStore()) {
+			s.add(obj)
+		}
+	}
+
+	s.index()
+
+	if err := s.listOwners(c.listOwnerKind); err != nil {
+		return nil, err
+	}
+
+	for _, gvr := range c.extra {
+		objs := []*unstructured.Unstructured{}
+		if informer, ok := c.extraInformers[gvr]; ok {
+			for _, obj := range sortedObjects(informer.GetStore()) {
+				if u, ok := obj.(*unstructured.Unstructured); ok {
+					objs = append(objs, u)
+				}
+			}
+		}
+		s.Resources[gvr] = objs
+	}
+
+	return s, nil
+}
+
+// listOwnerKind lists the owners of a kind from its informer, which is
+// started and synced the first time the kind is referenced.
+func (c *Cache) listOwnerKind(gk schema.GroupKind, version string) ([]*metav1.PartialObjectMetadata, error) {
+	c.lock.Lock()
+	owner, ok := c.owners[gk]
+	if !ok {
+		mapping, err := c.client.RESTMapper.RESTMapping(gk, version)
+		if err != nil {
+			c.lock.Unlock()
+			// the owner kind is gone, the pods keep the owner they reference
+			klog.Errorf("Failed to find resource of owner kind %s: %v", gk, err)
+			return nil, nil
+		}
+		owner = &ownerInformer{
+			apiVersion: mapping.GroupVersionKind.GroupVersion().String(),
+			informer:   c.dynamicFactory.ForResource(mapping.Resource).Informer(),
+		}
+		owner.informer.AddEventHandler(c.changeHandler())
+		c.owners[gk] = owner
+		c.dynamicFactory.Start(c.stopCh)
+	}
+	syncFailed := owner.syncFailed
+	c.lock.Unlock()
+
+	if !owner.informer.HasSynced() {
+		// the pods keep the owner they reference
+		if syncFailed {
+			return nil, nil
+		}
+		if !waitForSync(c.stopCh, ownerSyncTimeout, owner.informer.HasSynced) {
+			klog.Errorf("Failed to sync owner kind %s within %s, it is skipped until synced", gk, ownerSyncTimeout)
+			c.lock.Lock()
+			owner.syncFailed = true
+			c.lock.Unlock()
+			return nil, nil
+		}
+	}
+
+	var owners []*metav1.PartialObjectMetadata
+	for _, obj := range sortedObjects(owner.informer.GetStore()) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		owners = append(owners, newOwner(owner.apiVersion, gk.Kind, accessor))
+	}
+	return owners, nil
+}
+
+// waitForSync waits until the informer synced, false if stopCh is closed or
+// the timeout expires before.
+func waitForSync(stopCh <-chan struct{}, timeout time.Duration, synced cache.InformerSynced) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return cache.WaitForCacheSync(ctx.Done(), synced)
+}
+
+// add adds an object of a kind the checkers read to the snapshot.
+func (s *Snapshot) add(obj interface{}) {
+	switch o := obj.(type) {
+	case *corev1.Node:
+		s.Nodes = append(s.Nodes, o)
+	case *corev1.Pod:
+		s.Pods = append(s.Pods, o)
+	case *appsv1.ReplicaSet:
+		s.ReplicaSets = append(s.ReplicaSets, o)
+	case *appsv1.Deployment:
+		s.Deployments = append(s.Deployments, o)
+	case *appsv1.StatefulSet:
+		s.StatefulSets = append(s.StatefulSets, o)
+	case *appsv1.DaemonSet:
+		s.DaemonSets = append(s.DaemonSets, o)
+	case *policyv1beta1.PodDisruptionBudget:
+		s.PodDisruptionBudgets = append(s.PodDisruptionBudgets, o)
+	case *corev1.Service:
+		s.Services = append(s.Services, o)
+	case *corev1.Endpoints:
+		s.Endpoints = append(s.Endpoints, o)
+	case *corev1.PersistentVolumeClaim:
+		s.PersistentVolumeClaims = append(s.PersistentVolumeClaims, o)
+	case *corev1.PersistentVolume:
+		s.PersistentVolumes = append(s.PersistentVolumes, o)
+	}
+}
+
+// sortedObjects lists the store ordered by namespace and name, so snapshots
+// of the same state are the same.
+func sortedObjects(store cache.Store) []interface{} {
+	keys := store.ListKeys()
+	sort.Strings(keys)
+	objs := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if obj, exists, err := store.GetByKey(key); err == nil && exists {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func TestCacheSkipsOwnerKindFailingToSync(t *testing.T) {
+	defer func(timeout time.Duration) { ownerSyncTimeout = timeout }(ownerSyncTimeout)
+	ownerSyncTimeout = 200 * time.Millisecond
+
+	clusters := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "clusters"}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(clusters.GroupVersion().WithKind("Cluster"), meta.RESTScopeNamespace)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dynamicClient.PrependReactor("list", "clusters", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(clusters.GroupResource(), "", nil)
+	})
+
+	controller := true
+	client := &utils.KubeCient{
+		ClientSet: fake.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db-0",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "example.com/v1", Kind: "Cluster", Name: "db", Controller: &controller},
+				},
+			},
+		}),
+		DynamicClient: dynamicClient,
+		RESTMapper:    mapper,
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c := NewCache(client)
+	c.Start(stopCh)
+	if !c.WaitForCacheSync(stopCh) {
+		t.Fatalf("failed to sync the cache")
+	}
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		s, err := c.Snapshot()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(s.Owners) != 0 {
+			t.Errorf("expected no owner of the forbidden kind, got %d", len(s.Owners))
+		}
+		// only the first snapshot waits for the owner kind
+		if elapsed := time.Since(start); i > 0 && elapsed >= ownerSyncTimeout {
+			t.Errorf("expected snapshot %d to skip the owner kind, took %s", i, elapsed)
+		}
+	}
+}
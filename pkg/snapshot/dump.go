@@ -0,0 +1,208 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FromDump loads the cluster state from the output of `kubectl get -o json`
+// or `-o yaml`, a single file or a directory of .json, .yaml and .yml files.
+// Lists and multi document streams are unpacked. Objects of other kinds are
+// kept as possible pod owners and as extra resources, extra resources missing
+// from the dump are left empty. Archives written by WriteArchive are replayed
+// as they were captured.
+func FromDump(path string, extra ...schema.GroupVersionResource) (*Snapshot, error) {
+	if IsArchive(path) {
+		return FromArchive(path, extra...)
+	}
+
+	fmt.Fprintln(os.Stderr, "starting loading cluster state from dump...")
+	s := &Snapshot{
+		Resources: make(map[schema.GroupVersionResource][]*unstructured.Unstructured),
+	}
+
+	files, err := dumpFiles(path)
+	if err != nil {
+		klog.Errorf("Failed to read dump %s: %v", path, err)
+		return nil, err
+	}
+	for _, file := range files {
+		if err := s.loadFile(file); err != nil {
+			klog.Errorf("Failed to load dump file %s: %v", file, err)
+			return nil, err
+		}
+	}
+
+	for _, gvr := range extra {
+		if _, ok := s.Resources[gvr]; !ok {
+			s.Resources[gvr] = []*unstructured.Unstructured{}
+		}
+	}
+
+	s.index()
+	return s, nil
+}
+
+func dumpFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".json", ".yaml", ".yml":
+			if !info.IsDir() {
+				files = append(files, file)
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (s *Snapshot) loadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return decodeAll(f, s.load)
+}
+
+// decodeAll decodes the json or yaml stream and calls add with every object,
+// the items of lists one by one.
+func decodeAll(r io.Reader, add func(u *unstructured.Unstructured) error) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		// empty yaml documents
+		if len(obj) == 0 {
+			continue
+		}
+		if err := decodeItems(&unstructured.Unstructured{Object: obj}, add); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeItems(u *unstructured.Unstructured, add func(u *unstructured.Unstructured) error) error {
+	if !u.IsList() {
+		return add(u)
+	}
+	list, err := u.ToList()
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		if err := decodeItems(&list.Items[i], add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load adds the object to the snapshot.
+func (s *Snapshot) load(u *unstructured.Unstructured) error {
+	gvk := u.GroupVersionKind()
+	if gvk.Kind == "" {
+		return fmt.Errorf("object %s/%s has no kind", u.GetNamespace(), u.GetName())
+	}
+
+	// the versions served by recent clusters, e.g. policy/v1, carry the
+	// fields of the versions the checkers read
+	var err error
+	switch gvk.GroupKind() {
+	case schema.GroupKind{Kind: "Node"}:
+		node := &corev1.Node{}
+		err = convert(u, node)
+		s.Nodes = append(s.Nodes, node)
+	case schema.GroupKind{Kind: "Pod"}:
+		pod := &corev1.Pod{}
+		err = convert(u, pod)
+		s.Pods = append(s.Pods, pod)
+	case schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}:
+		rs := &appsv1.ReplicaSet{}
+		err = convert(u, rs)
+		s.ReplicaSets = append(s.ReplicaSets, rs)
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		deploy := &appsv1.Deployment{}
+		err = convert(u, deploy)
+		s.Deployments = append(s.Deployments, deploy)
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		sts := &appsv1.StatefulSet{}
+		err = convert(u, sts)
+		s.StatefulSets = append(s.StatefulSets, sts)
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+		ds := &appsv1.DaemonSet{}
+		err = convert(u, ds)
+		s.DaemonSets = append(s.DaemonSets, ds)
+	case schema.GroupKind{Group: "policy", Kind: "PodDisruptionBudget"}:
+		pdb := &policyv1beta1.PodDisruptionBudget{}
+		err = convert(u, pdb)
+		s.PodDisruptionBudgets = append(s.PodDisruptionBudgets, pdb)
+	case schema.GroupKind{Kind: "Service"}:
+		svc := &corev1.Service{}
+		err = convert(u, svc)
+		s.Services = append(s.Services, svc)
+	case schema.GroupKind{Kind: "Endpoints"}:
+		ep := &corev1.Endpoints{}
+		err = convert(u, ep)
+		s.Endpoints = append(s.Endpoints, ep)
+	case schema.GroupKind{Kind: "PersistentVolumeClaim"}:
+		pvc := &corev1.PersistentVolumeClaim{}
+		err = convert(u, pvc)
+		s.PersistentVolumeClaims = append(s.PersistentVolumeClaims, pvc)
+	case schema.GroupKind{Kind: "PersistentVolume"}:
+		pv := &corev1.PersistentVolume{}
+		err = convert(u, pv)
+		s.PersistentVolumes = append(s.PersistentVolumes, pv)
+	default:
+		// objects of other kinds are kept as possible pod owners and as
+		// extra resources, named by the usual plural of their kind
+		err = s.loadOwner(u)
+		gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+		s.Resources[gvr] = append(s.Resources[gvr], u)
+	}
+
+	return err
+}
+
+func convert(u *unstructured.Unstructured, obj interface{}) error {
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return fmt.Errorf("failed to convert %s %s/%s: %v", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+	}
+	return nil
+}
+
+// loadOwner keeps the metadata of the object as a possible pod owner.
+func (s *Snapshot) loadOwner(u *unstructured.Unstructured) error {
+	s.Owners = append(s.Owners, newOwner(u.GetAPIVersion(), u.GetKind(), u))
+	return nil
+}
@@ -0,0 +1,57 @@
+package snapshot
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromDump(t *testing.T) {
+	crds := schema.GroupVersionResource{Group: "crd.projectcalico.org", Version: "v1", Resource: "ipamhandles"}
+	s, err := FromDump(filepath.Join("testdata", "dump"), crds)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(s.Nodes) != 2 || s.Node("node-2") == nil {
+		t.Errorf("expected the nodes of the json list, got %d", len(s.Nodes))
+	}
+	if len(s.NodePods("node-1")) != 2 {
+		t.Errorf("expected 2 pods on node-1, got %d", len(s.NodePods("node-1")))
+	}
+	if s.Deployment("default", "web") == nil || s.ReplicaSet("default", "web-1") == nil {
+		t.Errorf("expected the deployment and replica set of the yaml list")
+	}
+
+	if len(s.PodDisruptionBudgets) != 1 {
+		t.Fatalf("expected 1 budget, got %d", len(s.PodDisruptionBudgets))
+	}
+	pdb := s.PodDisruptionBudgets[0]
+	if pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.IntValue() != 1 || pdb.Spec.Selector == nil {
+		t.Errorf("expected the spec of the policy/v1 budget, got %+v", pdb.Spec)
+	}
+
+	// the job is kept as owner, so the controller chain of its pod resolves
+	pod := s.Pod("default", "report-1-a")
+	if pod == nil {
+		t.Fatalf("expected pod report-1-a")
+	}
+	ref := s.ControllerOf(pod.Namespace, &pod.OwnerReferences[0])
+	if ref == nil || ref.Kind != "CronJob" {
+		t.Errorf("expected the job to be controlled by a cron job, got %v", ref)
+	}
+	jobs := s.Resource(schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"})
+	if len(jobs) != 1 {
+		t.Errorf("expected 1 job resource, got %d", len(jobs))
+	}
+
+	if objs, ok := s.Resources[crds]; !ok || len(objs) != 0 {
+		t.Errorf("expected the requested resource missing from the dump to be empty, got %v", objs)
+	}
+}
+
+func TestFromDumpErrors(t *testing.T) {
+	if _, err := FromDump(filepath.Join("testdata", "missing")); err == nil {
+		t.Errorf("expected an error for a missing dump")
+	}
+}
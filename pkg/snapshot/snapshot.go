@@ -0,0 +1,417 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"github.com/coderwangke/detect-drain/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/klog"
+	"os"
+)
+
+// MaxOwnerDepth bounds the walk up the controller chains, owner references
+// may form a cycle.
+const MaxOwnerDepth = 10
+
+// Snapshot is the in-memory state of the cluster the checkers read. Every
+// kind is listed once, page by page, and indexed afterwards.
+type Snapshot struct {
+	Nodes                  []*corev1.Node
+	Pods                   []*corev1.Pod
+	ReplicaSets            []*appsv1.ReplicaSet
+	Deployments            []*appsv1.Deployment
+	StatefulSets           []*appsv1.StatefulSet
+	DaemonSets             []*appsv1.DaemonSet
+	PodDisruptionBudgets   []*policyv1beta1.PodDisruptionBudget
+	Services               []*corev1.Service
+	Endpoints              []*corev1.Endpoints
+	PersistentVolumeClaims []*corev1.PersistentVolumeClaim
+	PersistentVolumes      []*corev1.PersistentVolume
+	// Owners are the pod owners of every other kind, e.g. Jobs, CronJobs or
+	// custom resources, reduced to their metadata.
+	Owners []*metav1.PartialObjectMetadata
+	// Resources are the objects of the extra resources requested by the
+	// checkers, e.g. the custom resources of a CNI plugin.
+	Resources map[schema.GroupVersionResource][]*unstructured.Unstructured
+
+	nodes         map[string]*corev1.Node
+	pods          map[string]*corev1.Pod
+	nodePods      map[string][]*corev1.Pod
+	namespacePods map[string][]*corev1.Pod
+	endpoints     map[string]*corev1.Endpoints
+	pvcs          map[string]*corev1.PersistentVolumeClaim
+	pvs           map[string]*corev1.PersistentVolume
+	// objects are the possible pod owners by kind/namespace/name
+	objects map[string]metav1.Object
+}
+
+// New lists everything the checkers need from the cluster, together with the
+// extra resources. Extra resources the cluster doesn't serve are left empty.
+func New(client *utils.KubeCient, extra ...schema.GroupVersionResource) (*Snapshot, error) {
+	fmt.Fprintln(os.Stderr, "starting snapshot cluster state...")
+	s := &Snapshot{
+		Resources: make(map[schema.GroupVersionResource][]*unstructured.Unstructured),
+	}
+	cs := client.ClientSet
+
+	lists := []struct {
+		kind string
+		list listFunc
+		add  func(obj runtime.Object)
+	}{
+		{
+			kind: "nodes",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.CoreV1().Nodes().List(opts) },
+			add:  func(obj runtime.Object) { s.Nodes = append(s.Nodes, obj.(*corev1.Node)) },
+		},
+		{
+			kind: "pods",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.CoreV1().Pods("").List(opts) },
+			add:  func(obj runtime.Object) { s.Pods = append(s.Pods, obj.(*corev1.Pod)) },
+		},
+		{
+			kind: "replicaSets",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.AppsV1().ReplicaSets("").List(opts) },
+			add:  func(obj runtime.Object) { s.ReplicaSets = append(s.ReplicaSets, obj.(*appsv1.ReplicaSet)) },
+		},
+		{
+			kind: "deployments",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.AppsV1().Deployments("").List(opts) },
+			add:  func(obj runtime.Object) { s.Deployments = append(s.Deployments, obj.(*appsv1.Deployment)) },
+		},
+		{
+			kind: "statefulSets",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.AppsV1().StatefulSets("").List(opts) },
+			add:  func(obj runtime.Object) { s.StatefulSets = append(s.StatefulSets, obj.(*appsv1.StatefulSet)) },
+		},
+		{
+			kind: "daemonSets",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.AppsV1().DaemonSets("").List(opts) },
+			add:  func(obj runtime.Object) { s.DaemonSets = append(s.DaemonSets, obj.(*appsv1.DaemonSet)) },
+		},
+		{
+			kind: "podDisruptionBudgets",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return cs.PolicyV1beta1().PodDisruptionBudgets("").List(opts)
+			},
+			add: func(obj runtime.Object) {
+				s.PodDisruptionBudgets = append(s.PodDisruptionBudgets, obj.(*policyv1beta1.PodDisruptionBudget))
+			},
+		},
+		{
+			kind: "services",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.CoreV1().Services("").List(opts) },
+			add:  func(obj runtime.Object) { s.Services = append(s.Services, obj.(*corev1.Service)) },
+		},
+		{
+			kind: "endpoints",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) { return cs.CoreV1().Endpoints("").List(opts) },
+			add:  func(obj runtime.Object) { s.Endpoints = append(s.Endpoints, obj.(*corev1.Endpoints)) },
+		},
+		{
+			kind: "persistentVolumeClaims",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return cs.CoreV1().PersistentVolumeClaims("").List(opts)
+			},
+			add: func(obj runtime.Object) {
+				s.PersistentVolumeClaims = append(s.PersistentVolumeClaims, obj.(*corev1.PersistentVolumeClaim))
+			},
+		},
+		{
+			kind: "persistentVolumes",
+			list: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return cs.CoreV1().PersistentVolumes().List(opts)
+			},
+			add: func(obj runtime.Object) {
+				s.PersistentVolumes = append(s.PersistentVolumes, obj.(*corev1.PersistentVolume))
+			},
+		},
+	}
+
+	for _, l := range lists {
+		if err := listAll(l.kind, l.list, l.add); err != nil {
+			return nil, err
+		}
+	}
+
+	s.index()
+
+	if err := s.listOwners(func(gk schema.GroupKind, version string) ([]*metav1.PartialObjectMetadata, error) {
+		return listOwnerKind(client, gk, version)
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, gvr := range extra {
+		if err := s.listResource(client, gvr); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+type listFunc func(opts metav1.ListOptions) (runtime.Object, error)
+
+func listAll(kind string, list listFunc, add func(obj runtime.Object)) error {
+	p := pager.New(pager.SimplePageFunc(list))
+	err := p.EachListItem(context.TODO(), metav1.ListOptions{}, func(obj runtime.Object) error {
+		add(obj)
+		return nil
+	})
+	if err != nil {
+		klog.Errorf("Failed to list %s: %v", kind, err)
+	}
+	return err
+}
+
+// ownerListFunc lists the objects of a pod owner kind.
+type ownerListFunc func(gk schema.GroupKind, version string) ([]*metav1.PartialObjectMetadata, error)
+
+// listOwners lists the kinds referenced by controller references which are
+// not in the snapshot yet, level by level up the controller chains.
+func (s *Snapshot) listOwners(list ownerListFunc) error {
+	listed := make(map[schema.GroupKind]bool)
+	var pending []metav1.Object
+	for _, pod := range s.Pods {
+		pending = append(pending, pod)
+	}
+
+	for depth := 0; depth < MaxOwnerDepth && len(pending) != 0; depth++ {
+		missing := make(map[schema.GroupKind]schema.GroupVersion)
+		for _, obj := range pending {
+			ref := metav1.GetControllerOf(obj)
+			if ref == nil {
+				continue
+			}
+			gv, err := schema.ParseGroupVersion(ref.APIVersion)
+			if err != nil {
+				klog.Errorf("Failed to parse apiVersion of owner %s/%s: %v", ref.Kind, ref.Name, err)
+				continue
+			}
+			gk := gv.WithKind(ref.Kind).GroupKind()
+			if s.object(gk, obj.GetNamespace(), ref.Name) == nil && !listed[gk] {
+				missing[gk] = gv
+			}
+		}
+
+		pending = pending[:0]
+		for gk, gv := range missing {
+			listed[gk] = true
+			owners, err := list(gk, gv.Version)
+			if err != nil {
+				return err
+			}
+			for _, owner := range owners {
+				s.Owners = append(s.Owners, owner)
+				s.objects[objectKey(owner.GroupVersionKind().GroupKind(), owner.Namespace, owner.Name)] = owner
+				pending = append(pending, owner)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Snapshot) listResource(client *utils.KubeCient, gvr schema.GroupVersionResource) error {
+	if _, ok := s.Resources[gvr]; ok {
+		return nil
+	}
+
+	objs := []*unstructured.Unstructured{}
+	resourceClient := client.DynamicClient.Resource(gvr)
+	err := listAll(gvr.String(), func(opts metav1.ListOptions) (runtime.Object, error) {
+		return resourceClient.List(opts)
+	}, func(obj runtime.Object) {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			objs = append(objs, u)
+		}
+	})
+	if apierrors.IsNotFound(err) {
+		err = nil
+	}
+	s.Resources[gvr] = objs
+	return err
+}
+
+func listOwnerKind(client *utils.KubeCient, gk schema.GroupKind, version string) ([]*metav1.PartialObjectMetadata, error) {
+	mapping, err := client.RESTMapper.RESTMapping(gk, version)
+	if err != nil {
+		// the owner kind is gone, the pods keep the owner they reference
+		klog.Errorf("Failed to find resource of owner kind %s: %v", gk, err)
+		return nil, nil
+	}
+
+	var owners []*metav1.PartialObjectMetadata
+	resourceClient := client.DynamicClient.Resource(mapping.Resource)
+	err = listAll(mapping.Resource.Resource, func(opts metav1.ListOptions) (runtime.Object, error) {
+		return resourceClient.List(opts)
+	}, func(obj runtime.Object) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		owners = append(owners, newOwner(mapping.GroupVersionKind.GroupVersion().String(), gk.Kind, accessor))
+	})
+	return owners, err
+}
+
+// newOwner reduces the object to the metadata the controller chains are
+// resolved by.
+func newOwner(apiVersion, kind string, obj metav1.Object) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiVersion,
+			Kind:       kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            obj.GetName(),
+			Namespace:       obj.GetNamespace(),
+			UID:             obj.GetUID(),
+			Labels:          obj.GetLabels(),
+			OwnerReferences: obj.GetOwnerReferences(),
+		},
+	}
+}
+
+func (s *Snapshot) index() {
+	s.nodes = make(map[string]*corev1.Node, len(s.Nodes))
+	s.pods = make(map[string]*corev1.Pod, len(s.Pods))
+	s.nodePods = make(map[string][]*corev1.Pod)
+	s.namespacePods = make(map[string][]*corev1.Pod)
+	s.endpoints = make(map[string]*corev1.Endpoints, len(s.Endpoints))
+	s.pvcs = make(map[string]*corev1.PersistentVolumeClaim, len(s.PersistentVolumeClaims))
+	s.pvs = make(map[string]*corev1.PersistentVolume, len(s.PersistentVolumes))
+	s.objects = make(map[string]metav1.Object)
+
+	for _, node := range s.Nodes {
+		s.nodes[node.Name] = node
+		s.objects[objectKey(nodeKind, "", node.Name)] = node
+	}
+	for _, pod := range s.Pods {
+		s.pods[pod.Namespace+"/"+pod.Name] = pod
+		// terminated pods neither use node resources nor get evicted
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		s.namespacePods[pod.Namespace] = append(s.namespacePods[pod.Namespace], pod)
+		if pod.Spec.NodeName != "" {
+			s.nodePods[pod.Spec.NodeName] = append(s.nodePods[pod.Spec.NodeName], pod)
+		}
+	}
+	for _, rs := range s.ReplicaSets {
+		s.objects[objectKey(replicaSetKind, rs.Namespace, rs.Name)] = rs
+	}
+	for _, deploy := range s.Deployments {
+		s.objects[objectKey(deploymentKind, deploy.Namespace, deploy.Name)] = deploy
+	}
+	for _, sts := range s.StatefulSets {
+		s.objects[objectKey(statefulSetKind, sts.Namespace, sts.Name)] = sts
+	}
+	for _, ds := range s.DaemonSets {
+		s.objects[objectKey(daemonSetKind, ds.Namespace, ds.Name)] = ds
+	}
+	for _, owner := range s.Owners {
+		s.objects[objectKey(owner.GroupVersionKind().GroupKind(), owner.Namespace, owner.Name)] = owner
+	}
+	for _, ep := range s.Endpoints {
+		s.endpoints[ep.Namespace+"/"+ep.Name] = ep
+	}
+	for _, pvc := range s.PersistentVolumeClaims {
+		s.pvcs[pvc.Namespace+"/"+pvc.Name] = pvc
+	}
+	for _, pv := range s.PersistentVolumes {
+		s.pvs[pv.Name] = pv
+	}
+}
+
+var (
+	nodeKind        = schema.GroupKind{Kind: "Node"}
+	replicaSetKind  = schema.GroupKind{Group: appsv1.GroupName, Kind: "ReplicaSet"}
+	deploymentKind  = schema.GroupKind{Group: appsv1.GroupName, Kind: "Deployment"}
+	statefulSetKind = schema.GroupKind{Group: appsv1.GroupName, Kind: "StatefulSet"}
+	daemonSetKind   = schema.GroupKind{Group: appsv1.GroupName, Kind: "DaemonSet"}
+)
+
+// objectKey includes the group, owners of different groups may share kind
+// and name.
+func objectKey(gk schema.GroupKind, ns, name string) string {
+	return gk.String() + "/" + ns + "/" + name
+}
+
+func (s *Snapshot) object(gk schema.GroupKind, ns, name string) metav1.Object {
+	if obj, ok := s.objects[objectKey(gk, ns, name)]; ok {
+		return obj
+	}
+	// owners of namespaced objects may be cluster scoped
+	return s.objects[objectKey(gk, "", name)]
+}
+
+func (s *Snapshot) Node(name string) *corev1.Node {
+	return s.nodes[name]
+}
+
+func (s *Snapshot) Pod(ns, name string) *corev1.Pod {
+	return s.pods[ns+"/"+name]
+}
+
+// NodePods returns the non terminated pods of the node.
+func (s *Snapshot) NodePods(name string) []*corev1.Pod {
+	return s.nodePods[name]
+}
+
+// NamespacePods returns the non terminated pods of the namespace.
+func (s *Snapshot) NamespacePods(ns string) []*corev1.Pod {
+	return s.namespacePods[ns]
+}
+
+func (s *Snapshot) EndpointsOf(ns, name string) *corev1.Endpoints {
+	return s.endpoints[ns+"/"+name]
+}
+
+func (s *Snapshot) PersistentVolumeClaim(ns, name string) *corev1.PersistentVolumeClaim {
+	return s.pvcs[ns+"/"+name]
+}
+
+func (s *Snapshot) PersistentVolume(name string) *corev1.PersistentVolume {
+	return s.pvs[name]
+}
+
+// ControllerOf returns the controller reference of the object ref points
+// to, nil if it has none or isn't in the snapshot.
+func (s *Snapshot) ControllerOf(ns string, ref *metav1.OwnerReference) *metav1.OwnerReference {
+	obj := s.object(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind(), ns, ref.Name)
+	if obj == nil {
+		return nil
+	}
+	return metav1.GetControllerOf(obj)
+}
+
+func (s *Snapshot) Deployment(ns, name string) *appsv1.Deployment {
+	deploy, _ := s.objects[objectKey(deploymentKind, ns, name)].(*appsv1.Deployment)
+	return deploy
+}
+
+func (s *Snapshot) StatefulSet(ns, name string) *appsv1.StatefulSet {
+	sts, _ := s.objects[objectKey(statefulSetKind, ns, name)].(*appsv1.StatefulSet)
+	return sts
+}
+
+func (s *Snapshot) ReplicaSet(ns, name string) *appsv1.ReplicaSet {
+	rs, _ := s.objects[objectKey(replicaSetKind, ns, name)].(*appsv1.ReplicaSet)
+	return rs
+}
+
+// Resource returns the objects of an extra resource.
+func (s *Snapshot) Resource(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+	return s.Resources[gvr]
+}
@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"testing"
+)
+
+func TestOwnersOfDifferentGroups(t *testing.T) {
+	controller := true
+	ref := func(apiVersion, kind, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: &controller}}
+	}
+	s := &Snapshot{Pods: []*corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "default", OwnerReferences: ref("b.example.com/v1", "Cluster", "db")},
+	}}}
+	s.index()
+
+	// both groups have a Cluster db, only the one of group b owns the pod
+	owners := map[string]*metav1.PartialObjectMetadata{
+		"a.example.com": newOwner("a.example.com/v1", "Cluster", &metav1.ObjectMeta{
+			Name: "db", Namespace: "default", OwnerReferences: ref("a.example.com/v1", "Operator", "a"),
+		}),
+		"b.example.com": newOwner("b.example.com/v1", "Cluster", &metav1.ObjectMeta{
+			Name: "db", Namespace: "default", OwnerReferences: ref("b.example.com/v1", "Operator", "b"),
+		}),
+	}
+	err := s.listOwners(func(gk schema.GroupKind, version string) ([]*metav1.PartialObjectMetadata, error) {
+		if gk.Kind != "Cluster" {
+			return nil, nil
+		}
+		// the other group is listed too, as if it was referenced elsewhere
+		return []*metav1.PartialObjectMetadata{owners["b.example.com"], owners["a.example.com"]}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	controllerOf := s.ControllerOf("default", &s.Pods[0].OwnerReferences[0])
+	if controllerOf == nil || controllerOf.Name != "b" {
+		t.Errorf("expected the Cluster db of group b to be controlled by operator b, got %v", controllerOf)
+	}
+}
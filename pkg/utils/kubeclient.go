@@ -1,20 +1,72 @@
 package utils
 
 import (
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
-	"time"
 )
 
+// KubeConfigFlags are the flags of kubectl selecting the cluster and the
+// credentials. The kubeconfig files are merged like kubectl does, from
+// --kubeconfig, $KUBECONFIG or ~/.kube/config, and the service account of
+// the pod is used when running in a cluster without any.
+type KubeConfigFlags struct {
+	KubeConfig string
+	Overrides  clientcmd.ConfigOverrides
+}
+
+func NewKubeConfigFlags() *KubeConfigFlags {
+	return &KubeConfigFlags{
+		Overrides: clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults},
+	}
+}
+
+func (f *KubeConfigFlags) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&f.KubeConfig, clientcmd.RecommendedConfigPathFlag, "", "Path to the kubeconfig file to use for CLI requests")
+	fs.StringVar(&f.KubeConfig, "kube-config", "", "Path to the kubeconfig file to use for CLI requests")
+	fs.MarkDeprecated("kube-config", "use --kubeconfig instead")
+
+	// the flag set of kubectl and of the plugins built on genericclioptions,
+	// which leaves out the deprecated basic authentication. The request
+	// timeout defaults to none like kubectl, a timeout would cut the watches
+	// of the informers.
+	flagNames := clientcmd.RecommendedConfigOverrideFlags("")
+	flagNames.ClusterOverrideFlags.APIServer.ShortName = "s"
+	flagNames.AuthOverrideFlags.Username = clientcmd.FlagInfo{}
+	flagNames.AuthOverrideFlags.Password = clientcmd.FlagInfo{}
+	// the analysis covers all namespaces
+	flagNames.ContextOverrideFlags.Namespace = clientcmd.FlagInfo{}
+	clientcmd.BindOverrideFlags(&f.Overrides, fs, flagNames)
+}
+
+// ToRESTConfig resolves the client config the way kubectl does.
+func (f *KubeConfigFlags) ToRESTConfig() (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.DefaultClientConfig = &clientcmd.DefaultClientConfig
+	loadingRules.ExplicitPath = f.KubeConfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &f.Overrides).ClientConfig()
+}
+
 type KubeCient struct {
-	KubeConfigPath string
-	ClientSet *kubernetes.Clientset
+	ConfigFlags *KubeConfigFlags
+	// ClientSet is an interface so the checkers run against a fake clientset
+	// in tests.
+	ClientSet kubernetes.Interface
+	// DynamicClient and RESTMapper reach the objects of any kind, e.g. the
+	// owners of pods created by custom controllers.
+	DynamicClient dynamic.Interface
+	RESTMapper    meta.RESTMapper
 }
 
-func NewKubeClient(kubeConfigPath string) (*KubeCient, error) {
+func NewKubeClient(configFlags *KubeConfigFlags) (*KubeCient, error) {
 	client := &KubeCient{
-		KubeConfigPath: kubeConfigPath,
+		ConfigFlags: configFlags,
 	}
 
 	err := client.build()
@@ -26,19 +78,26 @@ func NewKubeClient(kubeConfigPath string) (*KubeCient, error) {
 }
 
 func (c *KubeCient) build() error {
-	config, err := clientcmd.BuildConfigFromFlags("", c.KubeConfigPath)
+	config, err := c.ConfigFlags.ToRESTConfig()
 	if err != nil {
 		klog.Errorf("Fail to build config from flags: %v", err)
 		return err
 	}
 
-	config.Timeout = time.Second * 10
-
 	c.ClientSet, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		klog.Errorf("Fail to create clientSet: %v", err)
 		return err
 	}
 
+	c.DynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Errorf("Fail to create dynamic client: %v", err)
+		return err
+	}
+
+	// discovery is only queried for the kinds actually looked up
+	c.RESTMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(c.ClientSet.Discovery()))
+
 	return nil
-}
\ No newline at end of file
+}